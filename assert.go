@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// assertions are the builtins that check a condition. They are passed the
+// expressions of their arguments rather than their values, so that a
+// failure can show the source of each, and assert_error can catch the
+// error raised by its argument. The message, if given, is only evaluated
+// when the assertion fails.
+var assertions = map[string]bool{"assert": true, "assert_eq": true, "assert_ne": true, "assert_error": true}
+
+// operand is an argument of a failed assertion, shown as label, then the
+// source of e and its value v.
+type operand struct {
+	label string
+	e     expressionVisitor
+	v     *expression
+}
+
+func (c *callExpression) visitAssertion(scope *scope) *expression {
+	required := 2
+	if c.name == "assert" || c.name == "assert_error" {
+		required = 1
+	}
+	if len(c.arguments) != required && len(c.arguments) != required+1 {
+		fail("%s: expected %d or %d arguments, got %d", c.name, required, required+1, len(c.arguments))
+	}
+	var message expressionVisitor
+	if len(c.arguments) > required {
+		message = c.arguments[required]
+	}
+	switch c.name {
+	case "assert":
+		v := c.arguments[0].visitExpression(scope)
+		typeCheck(booleanType, v)
+		if !v.value.(bool) {
+			c.failAssertion(scope, message, nil, nil, operand{"", c.arguments[0], v})
+		}
+	case "assert_eq", "assert_ne":
+		actual, expected := c.arguments[0].visitExpression(scope), c.arguments[1].visitExpression(scope)
+		same := actual == nil && expected == nil || actual != nil && expected != nil && equal(actual, expected)
+		if c.name == "assert_eq" && !same {
+			c.failAssertion(scope, message, expected, actual, operand{"actual:   ", c.arguments[0], actual}, operand{"expected: ", c.arguments[1], expected})
+		} else if c.name == "assert_ne" && same {
+			c.failAssertion(scope, message, nil, nil, operand{"left:  ", c.arguments[0], actual}, operand{"right: ", c.arguments[1], expected})
+		}
+	case "assert_error":
+		v, err := tryExpression(c.arguments[0], scope)
+		if err == nil {
+			c.failAssertion(scope, message, nil, nil, operand{"no error: ", c.arguments[0], v})
+		}
+		return &expression{stringType, err.Error()}
+	}
+	return nil
+}
+
+// tryExpression evaluates e, recovering from the runtime error it raises
+// and exiting the functions it raised it in.
+func tryExpression(e expressionVisitor, scope *scope) (v *expression, err error) {
+	depth := len(calls)
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*runtimeError)
+			if !ok {
+				panic(r)
+			}
+			unwind(depth)
+			err = e
+		}
+	}()
+	return e.visitExpression(scope), nil
+}
+
+// failAssertion raises the error reporting that c failed, with its message
+// if not nil and a line for each of its operands.
+func (c *callExpression) failAssertion(scope *scope, message expressionVisitor, expected, actual *expression, operands ...operand) {
+	var b strings.Builder
+	b.WriteString(sourceText(c) + " failed")
+	if c.file != "" {
+		fmt.Fprintf(&b, " in %s", c.file)
+	}
+	fmt.Fprintf(&b, " at line %d, column %d", c.line, c.column)
+	if message != nil {
+		m := message.visitExpression(scope)
+		typeCheck(stringType, m)
+		b.WriteString(": " + m.value.(string))
+	}
+	for _, o := range operands {
+		text, value := sourceText(o.e), quoteValue(o.v)
+		if text == value {
+			fmt.Fprintf(&b, "\n  %s%s", o.label, value)
+		} else {
+			fmt.Fprintf(&b, "\n  %s%s = %s", o.label, text, value)
+		}
+		if o.v != nil {
+			fmt.Fprintf(&b, " (%s)", typeOf(o.v))
+		}
+	}
+	panic(&runtimeError{b.String(), true, expected, actual})
+}
+
+// sourceText formats e as it would appear in formatted source.
+func sourceText(e expressionVisitor) string {
+	f := &formatter{}
+	e.formatExpression(f)
+	return f.String()
+}
+
+// quoteValue describes e on one line, escaping strings.
+func quoteValue(e *expression) string {
+	if e != nil && e.typeValue == stringType {
+		return strconv.Quote(e.value.(string))
+	}
+	return describeSymbol(e)
+}
+
+// typeOf names the type of e, with the struct, class or enum it belongs
+// to.
+func typeOf(e *expression) string {
+	switch v := e.value.(type) {
+	case *structValue:
+		return "struct " + v.definition.name
+	case *object:
+		return "class " + v.class.name
+	case *variantValue:
+		return "enum " + v.enum.name
+	}
+	return types[e.typeValue]
+}
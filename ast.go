@@ -2,95 +2,323 @@ package main
 
 type (
 	assignmentStatement struct {
+		pos
 		id         string
 		expression expressionVisitor
 	}
 
+	bindingPattern struct {
+		pos
+		name string
+	}
+
+	// block is a sequence of statements. end is where the token closing it
+	// starts.
 	block struct {
+		pos
 		statements []statementVisitor
+		end        pos
 	}
 
 	booleanExpression struct {
+		pos
 		left     expressionVisitor
 		operator string
 		right    expressionVisitor
 	}
 
 	booleanLiteral struct {
+		pos
 		value bool
 	}
 
 	breakStatement struct {
+		pos
+		label string
 	}
 
+	// callExpression is a call in file, which assertions report failing
+	// in. end is where the token closing its arguments starts.
 	callExpression struct {
+		pos
+		receiver  expressionVisitor
 		name      string
 		arguments []expressionVisitor
+		end       pos
+		file      string
+	}
+
+	// classStatement is a class. end is where the token closing it
+	// starts.
+	classStatement struct {
+		pos
+		name    string
+		parent  string
+		fields  []*declarationStatement
+		methods []*functionStatement
+		end     pos
+	}
+
+	// comment is a // comment, kept only for formatting.
+	comment struct {
+		pos
+		text string
 	}
 
 	continueStatement struct {
+		pos
+		label string
 	}
 
 	declarationStatement struct {
+		pos
 		id         string
+		typeName   string
 		expression expressionVisitor
 	}
 
+	enumStatement struct {
+		pos
+		name     string
+		variants []*enumVariant
+		end      pos
+	}
+
+	enumVariant struct {
+		pos
+		name       string
+		fields     []string
+		fieldTypes []string
+	}
+
+	exportStatement struct {
+		pos
+		name      string
+		statement statementVisitor
+	}
+
 	expressionVisitor interface {
 		String() string
+		position() pos
+		checkExpression(c *checker) expressionType
+		inferExpression(in *inferrer) inferredType
 		visitExpression(scope *scope) *expression
+		formatExpression(f *formatter)
 	}
 
-	functionStatement struct {
-		name       string
-		parameters []string
+	fieldAssignmentStatement struct {
+		pos
+		object     expressionVisitor
+		field      string
+		expression expressionVisitor
+	}
+
+	fieldExpression struct {
+		pos
+		object expressionVisitor
+		field  string
+	}
+
+	forStatement struct {
+		pos
+		label      string
+		key        string
+		value      string
+		collection expressionVisitor
 		block      *block
 	}
 
+	// functionStatement is a function. parameterPositions are where its
+	// parameters start and parametersEnd where the token closing them
+	// starts.
+	functionStatement struct {
+		pos
+		name               string
+		parameters         []string
+		parameterTypes     []string
+		parameterPositions []pos
+		parametersEnd      pos
+		returnType         string
+		block              *block
+	}
+
 	identifier struct {
+		pos
 		value string
 	}
 
 	ifStatement struct {
+		pos
 		booleanExpression expressionVisitor
 		block             *block
 	}
 
+	importStatement struct {
+		pos
+		path   string
+		module *module
+	}
+
+	// interpolationExpression is a string with embedded expressions, whose
+	// values are placed between its segments.
+	interpolationExpression struct {
+		pos
+		segments    []string
+		expressions []expressionVisitor
+	}
+
+	// listLiteral is a list. end is where the token closing it starts, as
+	// for the other literals and calls with elements.
+	listLiteral struct {
+		pos
+		elements []expressionVisitor
+		end      pos
+	}
+
+	literalPattern struct {
+		pos
+		literal expressionVisitor
+	}
+
 	logicalNotExpression struct {
+		pos
 		booleanExpression expressionVisitor
 	}
 
 	logicalOperand struct {
+		pos
 		left     expressionVisitor
 		operator string
 		right    expressionVisitor
 	}
 
+	mapLiteral struct {
+		pos
+		keys   []expressionVisitor
+		values []expressionVisitor
+		end    pos
+	}
+
+	matchArm struct {
+		pos
+		pattern patternVisitor
+		guard   expressionVisitor
+		body    expressionVisitor
+	}
+
+	matchExpression struct {
+		pos
+		subject expressionVisitor
+		arms    []*matchArm
+		end     pos
+	}
+
 	numberLiteral struct {
+		pos
 		value string
 	}
 
 	returnStatement struct {
+		pos
 		expression expressionVisitor
 	}
 
+	// patternVisitor is a pattern of a match arm. matchPattern reports
+	// whether e matches, declaring the variables the pattern binds in scope.
+	patternVisitor interface {
+		String() string
+		position() pos
+		checkPattern(c *checker, t expressionType)
+		inferPattern(in *inferrer, t inferredType)
+		matchPattern(e *expression, scope *scope) bool
+		formatPattern(f *formatter)
+	}
+
+	// pos is the line and column a node starts at in its source.
+	pos struct {
+		line   int
+		column int
+	}
+
 	statementVisitor interface {
 		String() string
+		position() pos
+		checkStatement(c *checker)
+		inferStatement(in *inferrer)
 		visitStatement(scope *scope) *statement
+		formatStatement(f *formatter)
 	}
 
 	stringLiteral struct {
+		pos
 		value string
 	}
 
+	structLiteral struct {
+		pos
+		name   string
+		fields []string
+		values []expressionVisitor
+		end    pos
+	}
+
+	structStatement struct {
+		pos
+		name       string
+		fields     []string
+		fieldTypes []string
+	}
+
+	superExpression struct {
+		pos
+		class string
+	}
+
 	term struct {
+		pos
 		left     expressionVisitor
 		operator string
 		right    expressionVisitor
 	}
 
+	// testStatement is a test, run by the test command only.
+	testStatement struct {
+		pos
+		name  string
+		block *block
+	}
+
+	variantExpression struct {
+		pos
+		enum      string
+		variant   string
+		arguments []expressionVisitor
+		end       pos
+	}
+
+	variantPattern struct {
+		pos
+		enum     string
+		variant  string
+		patterns []patternVisitor
+	}
+
 	whileStatement struct {
+		pos
+		label             string
 		booleanExpression expressionVisitor
 		block             *block
 	}
+
+	wildcardPattern struct {
+		pos
+	}
 )
+
+func (p pos) position() pos {
+	return p
+}
+
+func (p pos) before(q pos) bool {
+	return p.line < q.line || p.line == q.line && p.column < q.column
+}
@@ -2,27 +2,140 @@ package main
 
 import (
 	"fmt"
+	"io"
 )
 
+// builtinTypes maps each builtin to the type of value it produces, 0 if it
+// produces none.
+var builtinTypes = map[string]expressionType{
+	"print":        0,
+	"range":        rangeType,
+	"abs":          numberType,
+	"min":          numberType,
+	"max":          numberType,
+	"pow":          numberType,
+	"sqrt":         numberType,
+	"floor":        numberType,
+	"ceil":         numberType,
+	"round":        numberType,
+	"sin":          numberType,
+	"cos":          numberType,
+	"tan":          numberType,
+	"asin":         numberType,
+	"acos":         numberType,
+	"atan":         numberType,
+	"atan2":        numberType,
+	"exp":          numberType,
+	"log":          numberType,
+	"mod":          numberType,
+	"gcd":          numberType,
+	"random":       numberType,
+	"random_int":   numberType,
+	"seed":         0,
+	"eprint":       0,
+	"read_line":    stringType,
+	"eof":          booleanType,
+	"read_file":    stringType,
+	"write_file":   0,
+	"append_file":  0,
+	"lines":        listType,
+	"exists":       booleanType,
+	"list_dir":     listType,
+	"args":         listType,
+	"env":          stringType,
+	"exit":         0,
+	"format":       stringType,
+	"printf":       0,
+	"write":        0,
+	"assert":       0,
+	"assert_eq":    0,
+	"assert_ne":    0,
+	"assert_error": stringType,
+}
+
 func builtin(name string, args []*expression) (*expression, error) {
 	switch name {
 	case "print":
-		print(args)
+		print(stdout, args)
+	case "range":
+		return rangeBuiltin(args)
+	case "eprint", "read_line", "eof", "read_file", "write_file", "append_file", "lines", "exists", "list_dir":
+		return ioBuiltin(name, args)
+	case "args", "env", "exit":
+		return processBuiltin(name, args)
+	case "format", "printf", "write":
+		return printfBuiltin(name, args)
 	default:
-		return nil, fmt.Errorf("could not find fn: '%s'", name)
+		return mathBuiltin(name, args)
 	}
 	return nil, nil
 }
 
-func print(args []*expression) {
+// expectArguments checks that the builtin name received count arguments
+// of type t, or at least one when count is -1.
+func expectArguments(name string, args []*expression, t expressionType, count int) error {
+	if count < 0 && len(args) == 0 {
+		return fmt.Errorf("%s: expected at least 1 argument, got 0", name)
+	}
+	if count >= 0 && len(args) != count {
+		if count == 1 {
+			return fmt.Errorf("%s: expected 1 argument, got %d", name, len(args))
+		}
+		return fmt.Errorf("%s: expected %d arguments, got %d", name, count, len(args))
+	}
 	for _, arg := range args {
-		switch arg.typeValue {
-		case stringType:
-			fmt.Printf("%s\n", arg.value.(string))
-		case numberType:
-			fmt.Printf("%d\n", arg.value.(int))
-		case booleanType:
-			fmt.Printf("%t\n", arg.value.(bool))
+		if arg.typeValue != t {
+			return fmt.Errorf("%s: expected %s, got %s", name, types[t], types[arg.typeValue])
 		}
 	}
+	return nil
+}
+
+func print(w io.Writer, args []*expression) {
+	for _, arg := range args {
+		fmt.Fprintln(w, display(arg))
+	}
+}
+
+// display is the text print shows for e: strings appear without quotes.
+func display(e *expression) string {
+	switch e.typeValue {
+	case stringType:
+		return e.value.(string)
+	case numberType:
+		return formatNumber(e.value)
+	case booleanType:
+		return fmt.Sprintf("%t", e.value.(bool))
+	}
+	return e.String()
+}
+
+func rangeBuiltin(args []*expression) (*expression, error) {
+	var bounds []int
+	for _, arg := range args {
+		if arg.typeValue != numberType {
+			return nil, fmt.Errorf("range: expected number, got %s", types[arg.typeValue])
+		}
+		n, ok := arg.value.(int)
+		if !ok {
+			return nil, fmt.Errorf("range: expected integer, got %s", formatNumber(arg.value))
+		}
+		bounds = append(bounds, n)
+	}
+	r := &rangeValue{0, 0, 1}
+	switch len(bounds) {
+	case 1:
+		r.end = bounds[0]
+	case 3:
+		r.step = bounds[2]
+		if r.step == 0 {
+			return nil, fmt.Errorf("range: step must not be zero")
+		}
+		fallthrough
+	case 2:
+		r.start, r.end = bounds[0], bounds[1]
+	default:
+		return nil, fmt.Errorf("range: expected 1 to 3 arguments, got %d", len(bounds))
+	}
+	return &expression{rangeType, r}, nil
 }
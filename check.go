@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+)
+
+// checker statically checks a program against its type annotations. Types
+// of unannotated variables are inferred from their initializers; a type of
+// 0 stands for a value whose type is unknown until run time and is
+// compatible with every type.
+type checker struct {
+	scope      *scope
+	functions  map[string]*functionStatement
+	structs    map[string]*structStatement
+	classes    map[string]*classStatement
+	enums      map[string]*enumStatement
+	modules    map[*module]*checker
+	prefix     string
+	returnType expressionType
+	errors     []string
+}
+
+func check(b *block) []string {
+	return checkModule(b, "", map[*module]*checker{}).errors
+}
+
+// checkModule checks the module b, prefixing its errors with prefix. The
+// modules it imports are checked once, however many times they are
+// imported.
+func checkModule(b *block, prefix string, modules map[*module]*checker) *checker {
+	c := &checker{newScope(constantScope(func(e *expression) interface{} { return numberType })), map[string]*functionStatement{}, map[string]*structStatement{}, map[string]*classStatement{}, map[string]*enumStatement{}, modules, prefix, 0, nil}
+	collectFunctions(b, c.functions)
+	b.checkStatement(c)
+	return c
+}
+
+func collectFunctions(s statementVisitor, functions map[string]*functionStatement) {
+	switch s := s.(type) {
+	case *block:
+		for _, statement := range s.statements {
+			collectFunctions(statement, functions)
+		}
+	case *ifStatement:
+		collectFunctions(s.block, functions)
+	case *whileStatement:
+		collectFunctions(s.block, functions)
+	case *forStatement:
+		collectFunctions(s.block, functions)
+	case *functionStatement:
+		functions[s.name] = s
+		collectFunctions(s.block, functions)
+	case *exportStatement:
+		collectFunctions(s.statement, functions)
+	case *testStatement:
+		collectFunctions(s.block, functions)
+	}
+}
+
+func (c *checker) errorf(pos pos, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	c.errors = append(c.errors, fmt.Sprintf("%s%s at line %d, column %d", c.prefix, message, pos.line, pos.column))
+}
+
+func (c *checker) expect(pos pos, expected expressionType, actual expressionType) {
+	if expected != 0 && actual != 0 && expected != actual {
+		c.errorf(pos, "type mismatch: %s != %s", types[actual], types[expected])
+	}
+}
+
+func (c *checker) parentOf(class *classStatement) *classStatement {
+	return c.classes[class.parent]
+}
+
+func (c *checker) annotation(typeName string) expressionType {
+	if _, ok := c.structs[typeName]; ok {
+		return structType
+	}
+	if _, ok := c.classes[typeName]; ok {
+		return objectType
+	}
+	if _, ok := c.enums[typeName]; ok {
+		return enumType
+	}
+	return typeNamed(typeName)
+}
+
+func (c *checker) checkBlock(b *block, scope *scope) {
+	parent := c.scope
+	c.scope = scope
+	b.checkStatement(c)
+	c.scope = parent
+}
+
+func (d *declarationStatement) checkStatement(c *checker) {
+	t := d.expression.checkExpression(c)
+	if d.typeName != "" {
+		c.expect(d.expression.position(), c.annotation(d.typeName), t)
+		t = c.annotation(d.typeName)
+	}
+	c.scope.declare(d.id, t)
+}
+
+func (a *assignmentStatement) checkStatement(c *checker) {
+	t := a.expression.checkExpression(c)
+	symbol := c.scope.resolve(a.id)
+	if symbol == nil {
+		c.errorf(a.pos, "unrecognized var: '%s'", a.id)
+		return
+	}
+	c.expect(a.expression.position(), symbol.value.(expressionType), t)
+}
+
+func (i *ifStatement) checkStatement(c *checker) {
+	c.expect(i.booleanExpression.position(), booleanType, i.booleanExpression.checkExpression(c))
+	c.checkBlock(i.block, newScope(c.scope))
+}
+
+func (w *whileStatement) checkStatement(c *checker) {
+	c.expect(w.booleanExpression.position(), booleanType, w.booleanExpression.checkExpression(c))
+	c.checkBlock(w.block, newScope(c.scope))
+}
+
+func (f *forStatement) checkStatement(c *checker) {
+	var key, value expressionType
+	switch t := f.collection.checkExpression(c); t {
+	case rangeType:
+		key, value = numberType, numberType
+	case stringType:
+		key, value = numberType, stringType
+	case listType:
+		key = numberType
+	case mapType, objectType, 0:
+	default:
+		c.errorf(f.collection.position(), "cannot iterate over %s", types[t])
+	}
+	scope := newScope(c.scope)
+	if f.key != "" {
+		scope.declare(f.key, key)
+	}
+	scope.declare(f.value, value)
+	c.checkBlock(f.block, scope)
+}
+
+func (b *breakStatement) checkStatement(c *checker) {
+}
+
+func (b *continueStatement) checkStatement(c *checker) {
+}
+
+func (f *functionStatement) checkStatement(c *checker) {
+	scope := newScope(c.scope)
+	for i, p := range f.parameters {
+		scope.declare(p, c.annotation(f.parameterTypes[i]))
+	}
+	returnType := c.returnType
+	c.returnType = c.annotation(f.returnType)
+	c.checkBlock(f.block, scope)
+	c.returnType = returnType
+}
+
+func (r *returnStatement) checkStatement(c *checker) {
+	if r.expression == nil {
+		if c.returnType != 0 {
+			c.errorf(r.pos, "missing return value of type %s", types[c.returnType])
+		}
+		return
+	}
+	c.expect(r.expression.position(), c.returnType, r.expression.checkExpression(c))
+}
+
+func (b *block) checkStatement(c *checker) {
+	for _, s := range b.statements {
+		s.checkStatement(c)
+	}
+}
+
+func (ce *callExpression) checkStatement(c *checker) {
+	ce.checkExpression(c)
+}
+
+func (ce *callExpression) checkExpression(c *checker) expressionType {
+	var args []expressionType
+	for _, arg := range ce.arguments {
+		args = append(args, arg.checkExpression(c))
+	}
+	if ce.receiver != nil {
+		c.expect(ce.receiver.position(), objectType, ce.receiver.checkExpression(c))
+		return 0
+	}
+	if class, ok := c.classes[ce.name]; ok {
+		if init, _ := methodIn(c.parentOf, class, "init"); init != nil {
+			c.checkArguments(ce, init, args)
+		} else if len(args) != 0 {
+			c.errorf(ce.pos, "class %s expects 0 arguments, got %d", class.name, len(args))
+		}
+		return objectType
+	}
+	f, ok := c.functions[ce.name]
+	if !ok {
+		t, ok := builtinTypes[ce.name]
+		if !ok {
+			c.errorf(ce.pos, "could not find fn: '%s'", ce.name)
+		}
+		return t
+	}
+	c.checkArguments(ce, f, args)
+	return c.annotation(f.returnType)
+}
+
+func (c *checker) checkArguments(ce *callExpression, f *functionStatement, args []expressionType) {
+	if len(args) != len(f.parameters) {
+		c.errorf(ce.pos, "fn '%s' expects %d arguments, got %d", f.name, len(f.parameters), len(args))
+		return
+	}
+	for i, t := range args {
+		c.expect(ce.arguments[i].position(), c.annotation(f.parameterTypes[i]), t)
+	}
+}
+
+func (b *booleanExpression) checkExpression(c *checker) expressionType {
+	left := b.left.checkExpression(c)
+	right := b.right.checkExpression(c)
+	switch b.operator {
+	case "and", "or":
+		c.expect(b.left.position(), booleanType, left)
+		c.expect(b.right.position(), booleanType, right)
+		return booleanType
+	}
+	c.expect(b.right.position(), left, right)
+	switch left {
+	case numberType, stringType, 0:
+	default:
+		if b.operator != "==" && b.operator != "!=" {
+			c.errorf(b.pos, "unrecognized operator '%s' for %s", b.operator, types[left])
+		}
+	}
+	return booleanType
+}
+
+func (l *logicalOperand) checkExpression(c *checker) expressionType {
+	c.expect(l.left.position(), numberType, l.left.checkExpression(c))
+	c.expect(l.right.position(), numberType, l.right.checkExpression(c))
+	return numberType
+}
+
+func (t *term) checkExpression(c *checker) expressionType {
+	c.expect(t.left.position(), numberType, t.left.checkExpression(c))
+	c.expect(t.right.position(), numberType, t.right.checkExpression(c))
+	return numberType
+}
+
+func (l *logicalNotExpression) checkExpression(c *checker) expressionType {
+	c.expect(l.booleanExpression.position(), booleanType, l.booleanExpression.checkExpression(c))
+	return booleanType
+}
+
+func (i *identifier) checkExpression(c *checker) expressionType {
+	if symbol := c.scope.resolve(i.value); symbol != nil {
+		return symbol.value.(expressionType)
+	}
+	return 0
+}
+
+func (n *numberLiteral) checkExpression(c *checker) expressionType {
+	return numberType
+}
+
+func (s *stringLiteral) checkExpression(c *checker) expressionType {
+	return stringType
+}
+
+func (i *interpolationExpression) checkExpression(c *checker) expressionType {
+	for _, e := range i.expressions {
+		e.checkExpression(c)
+	}
+	return stringType
+}
+
+func (b *booleanLiteral) checkExpression(c *checker) expressionType {
+	return booleanType
+}
+
+func (l *listLiteral) checkExpression(c *checker) expressionType {
+	for _, e := range l.elements {
+		e.checkExpression(c)
+	}
+	return listType
+}
+
+func (m *mapLiteral) checkExpression(c *checker) expressionType {
+	for i, k := range m.keys {
+		k.checkExpression(c)
+		m.values[i].checkExpression(c)
+	}
+	return mapType
+}
+
+func (s *structStatement) checkStatement(c *checker) {
+	c.structs[s.name] = s
+}
+
+func (s *structLiteral) checkExpression(c *checker) expressionType {
+	definition := c.structs[s.name]
+	for i, field := range s.fields {
+		t := s.values[i].checkExpression(c)
+		if definition != nil {
+			c.expect(s.values[i].position(), c.annotation(definition.fieldTypes[indexOf(definition.fields, field)]), t)
+		}
+	}
+	return structType
+}
+
+func (f *fieldExpression) checkExpression(c *checker) expressionType {
+	c.expectFields(f.object)
+	return 0
+}
+
+func (f *fieldAssignmentStatement) checkStatement(c *checker) {
+	c.expectFields(f.object)
+	f.expression.checkExpression(c)
+}
+
+func (c *checker) expectFields(e expressionVisitor) {
+	switch t := e.checkExpression(c); t {
+	case structType, objectType, 0:
+	default:
+		c.errorf(e.position(), "cannot access field of %s", types[t])
+	}
+}
+
+func (cs *classStatement) checkStatement(c *checker) {
+	c.classes[cs.name] = cs
+	outer := c.scope
+	c.scope = newScope(outer)
+	for _, field := range cs.fields {
+		field.checkStatement(c)
+	}
+	c.scope = newScope(outer)
+	c.scope.declare("self", objectType)
+	for _, method := range cs.methods {
+		method.checkStatement(c)
+	}
+	c.scope = outer
+}
+
+func (s *superExpression) checkExpression(c *checker) expressionType {
+	return objectType
+}
+
+func (e *enumStatement) checkStatement(c *checker) {
+	c.enums[e.name] = e
+}
+
+func (v *variantExpression) checkExpression(c *checker) expressionType {
+	var variant *enumVariant
+	if enum, ok := c.enums[v.enum]; ok {
+		variant = enum.variant(v.variant)
+	}
+	for i, arg := range v.arguments {
+		t := arg.checkExpression(c)
+		if variant != nil {
+			c.expect(arg.position(), c.annotation(variant.fieldTypes[i]), t)
+		}
+	}
+	return enumType
+}
+
+func (m *matchExpression) checkStatement(c *checker) {
+	m.checkExpression(c)
+}
+
+func (m *matchExpression) checkExpression(c *checker) expressionType {
+	var result expressionType
+	t := m.subject.checkExpression(c)
+	for i, arm := range m.arms {
+		outer := c.scope
+		c.scope = newScope(outer)
+		arm.pattern.checkPattern(c, t)
+		if arm.guard != nil {
+			c.expect(arm.guard.position(), booleanType, arm.guard.checkExpression(c))
+		}
+		body := arm.body.checkExpression(c)
+		c.scope = outer
+		if i == 0 {
+			result = body
+		} else {
+			c.expect(arm.body.position(), result, body)
+		}
+	}
+	return result
+}
+
+func (l *literalPattern) checkPattern(c *checker, t expressionType) {
+	c.expect(l.pos, t, l.literal.checkExpression(c))
+}
+
+func (b *bindingPattern) checkPattern(c *checker, t expressionType) {
+	c.scope.declare(b.name, t)
+}
+
+func (w *wildcardPattern) checkPattern(c *checker, t expressionType) {
+}
+
+func (v *variantPattern) checkPattern(c *checker, t expressionType) {
+	c.expect(v.pos, t, enumType)
+	var variant *enumVariant
+	if enum, ok := c.enums[v.enum]; ok {
+		variant = enum.variant(v.variant)
+	}
+	for i, pattern := range v.patterns {
+		var field expressionType
+		if variant != nil {
+			field = c.annotation(variant.fieldTypes[i])
+		}
+		pattern.checkPattern(c, field)
+	}
+}
+
+func (t *testStatement) checkStatement(c *checker) {
+	c.checkBlock(t.block, newScope(c.scope))
+}
+
+func (i *importStatement) checkStatement(c *checker) {
+	m, ok := c.modules[i.module]
+	if !ok {
+		m = checkModule(i.module.block, i.module.path+": ", c.modules)
+		c.modules[i.module] = m
+		c.errors = append(c.errors, m.errors...)
+	}
+	for _, e := range i.module.exports {
+		switch s := e.statement.(type) {
+		case *declarationStatement:
+			c.scope.declare(e.name, m.scope.resolve(e.name).value)
+		case *functionStatement:
+			c.functions[e.name] = s
+		case *structStatement:
+			c.structs[e.name] = s
+		case *classStatement:
+			c.classes[e.name] = s
+		case *enumStatement:
+			c.enums[e.name] = s
+		}
+	}
+}
+
+func (e *exportStatement) checkStatement(c *checker) {
+	e.statement.checkStatement(c)
+}
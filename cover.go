@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// coverage is an observer that counts the times each statement runs
+	// and each if and while condition is true and false.
+	coverage struct {
+		entries map[statementVisitor]*coverEntry
+	}
+
+	// coverEntry is the coverage of the statement at line and column of
+	// path. The entries of if and while statements are branches.
+	coverEntry struct {
+		path         string
+		line, column int
+		hits         int
+		branch       bool
+		taken        int
+		skipped      int
+	}
+)
+
+// The coverage profile starts with a mode line, followed by a line for each
+// statement:
+//
+//	path:line.column statement hits
+//	path:line.column branch hits taken skipped
+
+const coverMode = "mode: count"
+
+func newCoverage() *coverage {
+	c := &coverage{map[statementVisitor]*coverEntry{}}
+	statements := map[statementVisitor]*module{}
+	for _, m := range modules {
+		collectStatements(m.block, m, statements)
+	}
+	// Only the statements of blocks run on their own; the statements
+	// exported and the methods of classes are run by the statement
+	// containing them.
+	for s, m := range statements {
+		b, ok := s.(*block)
+		if !ok {
+			continue
+		}
+		for _, statement := range b.statements {
+			at := statement.position()
+			e := &coverEntry{path: m.path, line: at.line, column: at.column}
+			switch statement.(type) {
+			case *ifStatement, *whileStatement:
+				e.branch = true
+			}
+			c.entries[statement] = e
+		}
+	}
+	return c
+}
+
+func (c *coverage) statement(s statementVisitor, scope *scope) {
+	if e, ok := c.entries[s]; ok {
+		e.hits++
+	}
+}
+
+func (c *coverage) result(s statementVisitor, value *expression) {}
+
+func (c *coverage) branch(s statementVisitor, taken bool) {
+	if e, ok := c.entries[s]; ok {
+		if taken {
+			e.taken++
+		} else {
+			e.skipped++
+		}
+	}
+}
+
+func (c *coverage) enter(f *functionStatement, scope *scope) {}
+
+func (c *coverage) exit(f *functionStatement, result *expression) {}
+
+// report prints a summary of the coverage to standard error, and writes
+// the coverage profile to file if it is not "".
+func (c *coverage) report(file string) {
+	var entries []*coverEntry
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	coverSummary(os.Stderr, entries)
+	if file == "" {
+		return
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, coverMode)
+	for _, e := range entries {
+		if e.branch {
+			fmt.Fprintf(w, "%s:%d.%d branch %d %d %d\n", e.path, e.line, e.column, e.hits, e.taken, e.skipped)
+		} else {
+			fmt.Fprintf(w, "%s:%d.%d statement %d\n", e.path, e.line, e.column, e.hits)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func sortEntries(entries []*coverEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.line < b.line || a.line == b.line && a.column < b.column
+	})
+}
+
+func readCoverProfile(file string) ([]*coverEntry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) == 0 || lines[0] != coverMode {
+		return nil, fmt.Errorf("%s: not a coverage profile", file)
+	}
+	var entries []*coverEntry
+	for n, line := range lines[1:] {
+		e := &coverEntry{}
+		fields := strings.Fields(line)
+		valid := len(fields) == 3 && fields[1] == "statement" || len(fields) == 5 && fields[1] == "branch"
+		if valid {
+			i := strings.LastIndex(fields[0], ":")
+			_, err := fmt.Sscanf(fields[0][i+1:], "%d.%d", &e.line, &e.column)
+			valid = i > 0 && err == nil
+			if valid {
+				e.path, e.branch = fields[0][:i], fields[1] == "branch"
+			}
+			for j, count := range []*int{&e.hits, &e.taken, &e.skipped}[:len(fields)-2] {
+				if *count, err = strconv.Atoi(fields[j+2]); err != nil {
+					valid = false
+				}
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%s:%d: invalid line '%s'", file, n+2, line)
+		}
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// coverSummary prints the share of statements run and branches taken
+// both ways in each file.
+func coverSummary(w io.Writer, entries []*coverEntry) {
+	for _, group := range groupEntries(entries) {
+		statements, run, branches, both := 0, 0, 0, 0
+		for _, e := range group {
+			statements++
+			if e.hits > 0 {
+				run++
+			}
+			if e.branch {
+				branches += 2
+				if e.taken > 0 {
+					both++
+				}
+				if e.skipped > 0 {
+					both++
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s: %s of statements, %s of branches\n", group[0].path, percent(run, statements), percent(both, branches))
+	}
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// groupEntries splits the sorted entries by file.
+func groupEntries(entries []*coverEntry) [][]*coverEntry {
+	var groups [][]*coverEntry
+	for i, e := range entries {
+		if i == 0 || e.path != entries[i-1].path {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], e)
+	}
+	return groups
+}
+
+// coverLine is the coverage of a line of source: the least number of
+// times a statement starting on it ran, and the branches on it not taken
+// both ways.
+type coverLine struct {
+	hits    int
+	partial []string
+}
+
+func coverLines(group []*coverEntry) map[int]*coverLine {
+	lines := map[int]*coverLine{}
+	for _, e := range group {
+		l, ok := lines[e.line]
+		if !ok {
+			l = &coverLine{hits: e.hits}
+			lines[e.line] = l
+		}
+		if e.hits < l.hits {
+			l.hits = e.hits
+		}
+		if e.branch && e.hits > 0 && (e.taken == 0 || e.skipped == 0) {
+			l.partial = append(l.partial, fmt.Sprintf("branch at column %d taken %d, skipped %d", e.column, e.taken, e.skipped))
+		}
+	}
+	return lines
+}
+
+// annotate prints the source of each file, each line prefixed with the
+// times its statements ran and marked with ! where one never ran or a
+// branch was not taken both ways.
+func annotate(w io.Writer, entries []*coverEntry) error {
+	for _, group := range groupEntries(entries) {
+		source, err := ioutil.ReadFile(group[0].path)
+		if err != nil {
+			return err
+		}
+		coverSummary(w, group)
+		lines := coverLines(group)
+		for n, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+			count, marker, note := "", " ", ""
+			if l, ok := lines[n+1]; ok {
+				count = fmt.Sprint(l.hits)
+				if l.hits == 0 || len(l.partial) > 0 {
+					marker = "!"
+				}
+				if len(l.partial) > 0 {
+					note = "  // " + strings.Join(l.partial, "; ")
+				}
+			}
+			fmt.Fprintf(w, "%6s %s %s%s\n", count, marker, text, note)
+		}
+	}
+	return nil
+}
+
+const coverStyle = `body { font-family: sans-serif; }
+pre { font-family: monospace; }
+.run { background: #dfd; }
+.missed { background: #fdd; }
+.partial { background: #ffc; }
+.count { color: #888; display: inline-block; width: 5em; text-align: right; margin-right: 1em; }`
+
+// coverHTML writes a page showing the source of each file, lines run
+// green, lines never run red and lines with branches not taken both ways
+// yellow.
+func coverHTML(w io.Writer, entries []*coverEntry) error {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>coverage</title>\n<style>\n%s\n</style>\n</head>\n<body>\n", coverStyle)
+	for _, group := range groupEntries(entries) {
+		source, err := ioutil.ReadFile(group[0].path)
+		if err != nil {
+			return err
+		}
+		var summary strings.Builder
+		coverSummary(&summary, group)
+		fmt.Fprintf(w, "<h2>%s</h2>\n<pre>\n", html.EscapeString(strings.TrimSpace(summary.String())))
+		lines := coverLines(group)
+		for n, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+			class, count, title := "", "", ""
+			if l, ok := lines[n+1]; ok {
+				count = fmt.Sprint(l.hits)
+				switch {
+				case l.hits == 0:
+					class = "missed"
+				case len(l.partial) > 0:
+					class, title = "partial", strings.Join(l.partial, "; ")
+				default:
+					class = "run"
+				}
+			}
+			fmt.Fprintf(w, "<span class=\"%s\" title=\"%s\"><span class=\"count\">%s</span>%s</span>\n", class, html.EscapeString(title), count, html.EscapeString(text))
+		}
+		fmt.Fprintln(w, "</pre>")
+	}
+	fmt.Fprintln(w, "</body>\n</html>")
+	return nil
+}
+
+func coverCommand(args []string) {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	htmlFile := flags.String("html", "", "write an HTML report to the file instead of annotating the source")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "missing coverage profile")
+		os.Exit(2)
+	}
+	entries, err := readCoverProfile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *htmlFile == "" {
+		err = annotate(os.Stdout, entries)
+	} else {
+		var f *os.File
+		if f, err = os.Create(*htmlFile); err == nil {
+			err = coverHTML(f, entries)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The debug adapter speaks the Debug Adapter Protocol over standard input
+// and output, driving the same debugger as the debug command. The program
+// runs in its own goroutine once it is launched and configured; when it
+// stops, it blocks until a request resumes it, and the requests about
+// frames, scopes and variables are answered from its paused state. There
+// is a single thread, and the program's output is sent as output events.
+
+type (
+	adapter struct {
+		*debugger
+		in                   *bufio.Reader
+		launched, configured bool
+		resume               chan bool
+		// mutex guards seq, the number of the last message sent, and
+		// paused, which is set while the program waits to be resumed.
+		mutex  sync.Mutex
+		seq    int
+		paused bool
+		// exception is the runtime error the program stopped on.
+		exception string
+		// references holds the scopes and values whose variables may be
+		// requested, numbered from 1. They are forgotten when the program
+		// resumes.
+		references []interface{}
+	}
+
+	dapRequest struct {
+		Seq       int             `json:"seq"`
+		Type      string          `json:"type"`
+		Command   string          `json:"command"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+
+	dapResponse struct {
+		Seq        int         `json:"seq"`
+		Type       string      `json:"type"`
+		RequestSeq int         `json:"request_seq"`
+		Success    bool        `json:"success"`
+		Command    string      `json:"command"`
+		Message    string      `json:"message,omitempty"`
+		Body       interface{} `json:"body,omitempty"`
+	}
+
+	dapEvent struct {
+		Seq   int         `json:"seq"`
+		Type  string      `json:"type"`
+		Event string      `json:"event"`
+		Body  interface{} `json:"body,omitempty"`
+	}
+
+	// dapArguments holds the arguments of every request handled.
+	dapArguments struct {
+		Program     string   `json:"program"`
+		Args        []string `json:"args"`
+		StopOnEntry bool     `json:"stopOnEntry"`
+		Source      struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+		FrameID            int    `json:"frameId"`
+		VariablesReference int    `json:"variablesReference"`
+		Expression         string `json:"expression"`
+	}
+
+	dapVariable struct {
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		Type               string `json:"type,omitempty"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+
+	// dapOutput sends what the program prints as output events.
+	dapOutput struct {
+		a *adapter
+	}
+)
+
+// threadID identifies the program's only thread.
+const threadID = 1
+
+func dapCommand(args []string) {
+	a := &adapter{in: stdin, resume: make(chan bool)}
+	// The program reads from an empty input, since standard input carries
+	// the protocol.
+	stdin = bufio.NewReader(strings.NewReader(""))
+	stdout = &dapOutput{a}
+	for {
+		request := &dapRequest{}
+		err := readMessage(a.in, request)
+		if err == io.EOF {
+			os.Exit(0)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		body, err := a.handle(request)
+		response := &dapResponse{Type: "response", RequestSeq: request.Seq, Success: err == nil, Command: request.Command, Body: body}
+		if err != nil {
+			response.Message = err.Error()
+		}
+		a.send(response)
+		switch request.Command {
+		case "launch":
+			if err == nil {
+				a.event("initialized", nil)
+			}
+		case "disconnect", "terminate":
+			os.Exit(0)
+		}
+	}
+}
+
+// send numbers and writes message, which may be sent by the program as
+// well as by the request loop.
+func (a *adapter) send(message interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.seq++
+	switch m := message.(type) {
+	case *dapResponse:
+		m.Seq = a.seq
+	case *dapEvent:
+		m.Seq = a.seq
+	}
+	writeMessage(message)
+}
+
+func (a *adapter) event(event string, body interface{}) {
+	a.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+func (o *dapOutput) Write(p []byte) (int, error) {
+	o.a.event("output", map[string]string{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
+
+func (a *adapter) handle(request *dapRequest) (interface{}, error) {
+	var args dapArguments
+	if len(request.Arguments) > 0 {
+		if err := json.Unmarshal(request.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	switch request.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, a.launch(args)
+	case "setBreakpoints":
+		return a.setBreakpoints(args)
+	case "configurationDone":
+		a.configured = true
+		a.start()
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "main"}},
+		}, nil
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+	if a.debugger == nil {
+		return nil, fmt.Errorf("no program launched")
+	}
+	if !a.isPaused() {
+		return nil, fmt.Errorf("program is running")
+	}
+	switch request.Command {
+	case "continue":
+		a.continueWith(run)
+		return map[string]bool{"allThreadsContinued": true}, nil
+	case "next":
+		a.continueWith(stepOver)
+		return nil, nil
+	case "stepIn":
+		a.continueWith(stepIn)
+		return nil, nil
+	case "stepOut":
+		a.continueWith(stepOut)
+		return nil, nil
+	case "stackTrace":
+		return a.stackTrace(), nil
+	case "scopes":
+		f, err := a.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": a.scopes(f.scope)}, nil
+	case "variables":
+		if args.VariablesReference < 1 || args.VariablesReference > len(a.references) {
+			return nil, fmt.Errorf("unrecognized variables reference %d", args.VariablesReference)
+		}
+		return map[string]interface{}{"variables": a.variables(a.references[args.VariablesReference-1])}, nil
+	case "evaluate":
+		f := a.frames[len(a.frames)-1]
+		if args.FrameID != 0 {
+			var err error
+			if f, err = a.frame(args.FrameID); err != nil {
+				return nil, err
+			}
+		}
+		e, err := a.evaluate(args.Expression, f.scope)
+		if err != nil {
+			return nil, err
+		}
+		v := a.variable("", e)
+		return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+	}
+	return nil, fmt.Errorf("unsupported command '%s'", request.Command)
+}
+
+func (a *adapter) launch(args dapArguments) error {
+	if a.debugger != nil {
+		return fmt.Errorf("program already launched")
+	}
+	if info, err := os.Stat(args.Program); err != nil || info.IsDir() {
+		return fmt.Errorf("cannot open program '%s'", args.Program)
+	}
+	var err error
+	m := func() *module {
+		defer func() {
+			if r := recover(); r != nil {
+				e, ok := r.(*syntaxError)
+				if !ok {
+					panic(r)
+				}
+				err = e
+			}
+		}()
+		return load(args.Program)
+	}()
+	if err != nil {
+		return err
+	}
+	scriptArgs = args.Args
+	a.debugger = newDebugger(m)
+	a.pause = a.stop
+	if !args.StopOnEntry {
+		a.mode = run
+	}
+	a.launched = true
+	observers = append(observers, a.debugger)
+	a.start()
+	return nil
+}
+
+// start runs the program once it has been both launched and configured.
+func (a *adapter) start() {
+	if !a.launched || !a.configured {
+		return
+	}
+	go func() {
+		code := 0
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if exit, ok := r.(*exitError); ok {
+						code = exit.code
+						return
+					}
+					err, ok := r.(*runtimeError)
+					if !ok {
+						panic(r)
+					}
+					code = 1
+					a.event("output", map[string]string{"category": "stderr", "output": fmt.Sprintf("error: %s\n", err)})
+					a.evaluating = false
+					a.exception = err.Error()
+					a.pause("exception")
+				}
+			}()
+			a.main.evaluate()
+		}()
+		a.event("exited", map[string]int{"exitCode": code})
+		a.event("terminated", nil)
+	}()
+}
+
+// stop reports that the program stopped and waits for it to be resumed.
+func (a *adapter) stop(reason string) {
+	body := map[string]interface{}{"reason": reason, "threadId": threadID, "allThreadsStopped": true}
+	if reason == "exception" {
+		body["text"] = a.exception
+	}
+	a.mutex.Lock()
+	a.paused, a.references = true, nil
+	a.mutex.Unlock()
+	a.event("stopped", body)
+	<-a.resume
+}
+
+func (a *adapter) isPaused() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.paused
+}
+
+// continueWith resumes the paused program in mode.
+func (a *adapter) continueWith(mode stepMode) {
+	a.step(mode)
+	a.mutex.Lock()
+	a.paused = false
+	a.mutex.Unlock()
+	a.resume <- true
+}
+
+// setBreakpoints replaces the breakpoints in a source file, reporting
+// which lines have a statement to stop at.
+func (a *adapter) setBreakpoints(args dapArguments) (interface{}, error) {
+	if a.debugger == nil {
+		return nil, fmt.Errorf("no program launched")
+	}
+	path := args.Source.Path
+	for _, m := range a.modules {
+		if canonicalPath(m.path) == canonicalPath(path) {
+			path = m.path
+			break
+		}
+	}
+	a.breakpointsMutex.Lock()
+	defer a.breakpointsMutex.Unlock()
+	for l := range a.breakpoints {
+		if l.path == path {
+			delete(a.breakpoints, l)
+		}
+	}
+	breakpoints := []map[string]interface{}{}
+	for _, b := range args.Breakpoints {
+		l := location{path, b.Line}
+		result := map[string]interface{}{"verified": a.hasStatement(l), "line": b.Line}
+		if a.hasStatement(l) {
+			a.breakpoints[l] = &breakpoint{l, b.Condition}
+		} else {
+			result["message"] = fmt.Sprintf("no statement at %s", l)
+		}
+		breakpoints = append(breakpoints, result)
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// stackTrace lists the frames from the innermost out. Frames are
+// identified by their index in the debugger's frames plus 1.
+func (a *adapter) stackTrace() interface{} {
+	frames := []map[string]interface{}{}
+	for i := len(a.frames) - 1; i >= 0; i-- {
+		f := a.frames[i]
+		if f.statement == nil {
+			continue
+		}
+		name := "main"
+		if f.function != nil {
+			name = f.function.name
+		}
+		l := a.location(f.statement)
+		frames = append(frames, map[string]interface{}{
+			"id":     i + 1,
+			"name":   name,
+			"source": map[string]string{"path": l.path},
+			"line":   l.line,
+			"column": f.statement.position().column,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (a *adapter) frame(id int) (*frame, error) {
+	if id < 1 || id > len(a.frames) || a.frames[id-1].scope == nil {
+		return nil, fmt.Errorf("unrecognized frame %d", id)
+	}
+	return a.frames[id-1], nil
+}
+
+// scopes lists scope and each scope enclosing it, leaving out the math
+// constants.
+func (a *adapter) scopes(s *scope) []map[string]interface{} {
+	scopes := []map[string]interface{}{}
+	for depth := 0; s != nil && s.parent != nil; depth, s = depth+1, s.parent {
+		name := "Locals"
+		if s.parent.parent == nil {
+			name = "Globals"
+		} else if depth > 0 {
+			name = fmt.Sprintf("Enclosing %d", depth)
+		}
+		scopes = append(scopes, map[string]interface{}{"name": name, "variablesReference": a.reference(s), "expensive": false})
+	}
+	return scopes
+}
+
+func (a *adapter) reference(v interface{}) int {
+	a.references = append(a.references, v)
+	return len(a.references)
+}
+
+// variables lists the symbols of a scope, or the elements, entries or
+// fields of a value.
+func (a *adapter) variables(v interface{}) []*dapVariable {
+	variables := []*dapVariable{}
+	switch v := v.(type) {
+	case *scope:
+		var names []string
+		for name := range v.symbols {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch value := v.symbols[name].value.(type) {
+			case *expression:
+				variables = append(variables, a.variable(name, value))
+			case *functionValue:
+				variables = append(variables, &dapVariable{Name: name, Value: describeSymbol(value), Type: "function"})
+			default:
+				variables = append(variables, &dapVariable{Name: name, Value: describeSymbol(value), Type: "type"})
+			}
+		}
+	case *expression:
+		switch v.typeValue {
+		case listType:
+			for i, element := range v.value.([]*expression) {
+				variables = append(variables, a.variable(fmt.Sprintf("[%d]", i), element))
+			}
+		case mapType:
+			m := v.value.(*mapValue)
+			for _, key := range m.keys {
+				variables = append(variables, a.variable(key.String(), m.get(key)))
+			}
+		case structType:
+			s := v.value.(*structValue)
+			for _, field := range s.definition.fields {
+				variables = append(variables, a.variable(field, s.fields[field]))
+			}
+		case objectType:
+			o := v.value.(*object)
+			for _, field := range classFields(o.class) {
+				variables = append(variables, a.variable(field, o.fields[field]))
+			}
+		case enumType:
+			e := v.value.(*variantValue)
+			for i, value := range e.values {
+				variables = append(variables, a.variable(e.variant.fields[i], value))
+			}
+		}
+	}
+	return variables
+}
+
+// variable describes the value e named name, giving values that contain
+// others a reference to them.
+func (a *adapter) variable(name string, e *expression) *dapVariable {
+	if e == nil {
+		return &dapVariable{Name: name, Value: "nil"}
+	}
+	v := &dapVariable{Name: name, Value: e.String(), Type: types[e.typeValue]}
+	switch e.typeValue {
+	case listType, mapType, structType, objectType:
+		v.VariablesReference = a.reference(e)
+	case enumType:
+		if len(e.value.(*variantValue).values) > 0 {
+			v.VariablesReference = a.reference(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// debugger is an observer that pauses the program at breakpoints and
+	// between steps, reading commands from standard input.
+	debugger struct {
+		modules     map[statementVisitor]*module
+		main        *module
+		breakpoints map[location]*breakpoint
+		// breakpointsMutex guards breakpoints, which the debug adapter
+		// changes while the program runs.
+		breakpointsMutex sync.Mutex
+		watches          []string
+		frames           []*frame
+		mode             stepMode
+		// depth is the number of frames when the step being taken started.
+		depth int
+		// evaluating is set while an expression typed in is evaluated, so
+		// that the functions it calls run without stopping.
+		evaluating bool
+		// pause is called when the program stops, with the reason why, and
+		// returns when it is to resume.
+		pause func(reason string)
+	}
+
+	// frame is a function being run, paused at statement in scope. The
+	// bottom frame runs the main module, with a nil function.
+	frame struct {
+		function  *functionStatement
+		statement statementVisitor
+		scope     *scope
+	}
+
+	location struct {
+		path string
+		line int
+	}
+
+	breakpoint struct {
+		location
+		condition string
+	}
+
+	stepMode int
+)
+
+const (
+	run stepMode = iota
+	entry
+	stepIn
+	stepOver
+	stepOut
+)
+
+const debugHelp = `commands:
+  break [file:]line [if condition]  stop at line, when condition holds
+  delete [[file:]line]              remove the breakpoint at line, or all
+  breakpoints                       list breakpoints
+  continue                          run to the next breakpoint
+  next                              run to the next statement, stepping over calls
+  step                              run to the next statement, stepping into calls
+  out                               run until the current function returns
+  print expression                  show the value of expression
+  watch expression                  show the value of expression at each stop
+  unwatch n                         remove watch n
+  scope                             show the variables of each enclosing scope
+  backtrace                         show the call stack
+  list                              show the source around the current line
+  quit                              end the program
+commands may be abbreviated to their first letter, and bt for backtrace.`
+
+func debugCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "missing file")
+		os.Exit(2)
+	}
+	scriptArgs = args[1:]
+	m := load(args[0])
+	d := newDebugger(m)
+	d.pause = d.prompt
+	observers = append(observers, d)
+	defer func() {
+		if r := recover(); r != nil {
+			if exit, ok := r.(*exitError); ok {
+				fmt.Printf("program exited with status %d\n", exit.code)
+				panic(r)
+			}
+			err, ok := r.(*runtimeError)
+			if !ok {
+				panic(r)
+			}
+			fmt.Printf("error: %s\n", err)
+			d.evaluating = false
+			d.pause("exception")
+			os.Exit(1)
+		}
+	}()
+	m.evaluate()
+	fmt.Println("program finished")
+}
+
+func newDebugger(m *module) *debugger {
+	d := &debugger{modules: map[statementVisitor]*module{}, main: m, breakpoints: map[location]*breakpoint{}, mode: entry}
+	for _, loaded := range modules {
+		collectStatements(loaded.block, loaded, d.modules)
+	}
+	d.frames = []*frame{{nil, nil, nil}}
+	return d
+}
+
+// collectStatements maps each statement in s to the module m it is in.
+func collectStatements(s statementVisitor, m *module, statements map[statementVisitor]*module) {
+	statements[s] = m
+	switch s := s.(type) {
+	case *block:
+		for _, statement := range s.statements {
+			collectStatements(statement, m, statements)
+		}
+	case *ifStatement:
+		collectStatements(s.block, m, statements)
+	case *whileStatement:
+		collectStatements(s.block, m, statements)
+	case *forStatement:
+		collectStatements(s.block, m, statements)
+	case *functionStatement:
+		collectStatements(s.block, m, statements)
+	case *classStatement:
+		for _, method := range s.methods {
+			collectStatements(method, m, statements)
+		}
+	case *exportStatement:
+		collectStatements(s.statement, m, statements)
+	case *testStatement:
+		collectStatements(s.block, m, statements)
+	}
+}
+
+func (d *debugger) statement(s statementVisitor, scope *scope) {
+	if d.evaluating {
+		return
+	}
+	top := d.frames[len(d.frames)-1]
+	top.statement, top.scope = s, scope
+	stop, reason := false, "step"
+	switch d.mode {
+	case entry:
+		stop, reason = true, "entry"
+	case stepIn:
+		stop = true
+	case stepOver:
+		stop = len(d.frames) <= d.depth
+	case stepOut:
+		stop = len(d.frames) < d.depth
+	}
+	d.breakpointsMutex.Lock()
+	b, ok := d.breakpoints[d.location(s)]
+	d.breakpointsMutex.Unlock()
+	if ok && !stop {
+		stop, reason = b.condition == "", "breakpoint"
+		if !stop {
+			e, err := d.evaluate(b.condition, scope)
+			if err != nil {
+				fmt.Fprintf(stdout, "breakpoint %s: %s\n", b.location, err)
+			}
+			stop = err != nil || e.typeValue == booleanType && e.value.(bool)
+		}
+	}
+	if stop {
+		d.pause(reason)
+	}
+}
+
+// step resumes the program in mode.
+func (d *debugger) step(mode stepMode) {
+	d.mode, d.depth = mode, len(d.frames)
+}
+
+func (d *debugger) result(s statementVisitor, value *expression) {}
+
+func (d *debugger) branch(s statementVisitor, taken bool) {}
+
+func (d *debugger) enter(f *functionStatement, scope *scope) {
+	if !d.evaluating {
+		d.frames = append(d.frames, &frame{f, nil, scope})
+	}
+}
+
+func (d *debugger) exit(f *functionStatement, result *expression) {
+	if !d.evaluating {
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+}
+
+func (d *debugger) location(s statementVisitor) location {
+	if m, ok := d.modules[s]; ok {
+		return location{m.path, s.position().line}
+	}
+	return location{d.main.path, s.position().line}
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("%s:%d", l.path, l.line)
+}
+
+// prompt shows where the program stopped and runs commands until one of
+// them resumes it.
+func (d *debugger) prompt(reason string) {
+	top := d.frames[len(d.frames)-1]
+	if top.statement == nil {
+		return
+	}
+	at := d.location(top.statement)
+	fmt.Printf("stopped at %s\n", at)
+	d.list(at, 0)
+	for i, watch := range d.watches {
+		fmt.Printf("watch %d: %s = %s\n", i+1, watch, d.show(watch, top.scope))
+	}
+	for {
+		fmt.Print("(debug) ")
+		line, err := stdin.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			fmt.Println()
+			d.mode = run
+			d.breakpoints = map[location]*breakpoint{}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		command, argument := fields[0], strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		switch command {
+		case "c", "continue":
+			d.step(run)
+			return
+		case "n", "next":
+			d.step(stepOver)
+			return
+		case "s", "step":
+			d.step(stepIn)
+			return
+		case "o", "out":
+			d.step(stepOut)
+			return
+		case "q", "quit":
+			os.Exit(0)
+		case "b", "break":
+			d.setBreakpoint(argument, at)
+		case "d", "delete":
+			if argument == "" {
+				d.breakpoints = map[location]*breakpoint{}
+			} else if l, ok := d.parseLocation(argument, at); ok {
+				if _, ok := d.breakpoints[l]; !ok {
+					fmt.Printf("no breakpoint at %s\n", l)
+				}
+				delete(d.breakpoints, l)
+			}
+		case "breakpoints":
+			d.listBreakpoints()
+		case "p", "print":
+			fmt.Println(d.show(argument, top.scope))
+		case "w", "watch":
+			if argument == "" {
+				fmt.Println("missing expression")
+				continue
+			}
+			d.watches = append(d.watches, argument)
+			fmt.Printf("watch %d: %s = %s\n", len(d.watches), argument, d.show(argument, top.scope))
+		case "unwatch":
+			n, err := strconv.Atoi(argument)
+			if err != nil || n < 1 || n > len(d.watches) {
+				fmt.Printf("no watch '%s'\n", argument)
+				continue
+			}
+			d.watches = append(d.watches[:n-1], d.watches[n:]...)
+		case "scope", "locals":
+			d.showScopes(top.scope)
+		case "bt", "backtrace":
+			d.backtrace()
+		case "l", "list":
+			d.list(at, 5)
+		case "h", "help":
+			fmt.Println(debugHelp)
+		default:
+			fmt.Printf("unrecognized command '%s'; 'help' lists commands\n", command)
+		}
+	}
+}
+
+// parseLocation parses [file:]line, where file defaults to the file of at.
+func (d *debugger) parseLocation(text string, at location) (location, bool) {
+	path, line := at.path, text
+	if i := strings.LastIndex(text, ":"); i >= 0 {
+		path, line = text[:i], text[i+1:]
+		if file, ok := findModule(d.main.path, path); ok {
+			path = file
+		}
+		found := false
+		for _, m := range d.modules {
+			if canonicalPath(m.path) == canonicalPath(path) {
+				path, found = m.path, true
+				break
+			}
+		}
+		if !found {
+			fmt.Printf("unrecognized file '%s'\n", path)
+			return location{}, false
+		}
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil {
+		fmt.Printf("invalid line '%s'\n", line)
+		return location{}, false
+	}
+	return location{path, n}, true
+}
+
+func (d *debugger) setBreakpoint(argument string, at location) {
+	var condition string
+	if i := strings.Index(argument, " if "); i >= 0 {
+		argument, condition = argument[:i], strings.TrimSpace(argument[i+len(" if "):])
+	}
+	l, ok := d.parseLocation(strings.TrimSpace(argument), at)
+	if !ok {
+		return
+	}
+	if !d.hasStatement(l) {
+		fmt.Printf("no statement at %s\n", l)
+		return
+	}
+	d.breakpoints[l] = &breakpoint{l, condition}
+	if condition != "" {
+		fmt.Printf("breakpoint at %s if %s\n", l, condition)
+	} else {
+		fmt.Printf("breakpoint at %s\n", l)
+	}
+}
+
+func (d *debugger) hasStatement(l location) bool {
+	for s, m := range d.modules {
+		if _, ok := s.(*block); !ok && m.path == l.path && s.position().line == l.line {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *debugger) listBreakpoints() {
+	var breakpoints []*breakpoint
+	for _, b := range d.breakpoints {
+		breakpoints = append(breakpoints, b)
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		a, b := breakpoints[i], breakpoints[j]
+		return a.path < b.path || a.path == b.path && a.line < b.line
+	})
+	for _, b := range breakpoints {
+		if b.condition != "" {
+			fmt.Printf("%s if %s\n", b.location, b.condition)
+		} else {
+			fmt.Println(b.location)
+		}
+	}
+}
+
+// evaluate parses and evaluates text as an expression in scope.
+func (d *debugger) evaluate(text string, scope *scope) (e *expression, err error) {
+	lexOut := lexSource([]byte(text))
+	depth := len(calls)
+	defer func() {
+		if r := recover(); r != nil {
+			for range lexOut {
+			}
+			switch r := r.(type) {
+			case *syntaxError:
+				err = fmt.Errorf("%s", r.message)
+			case *runtimeError, *exitError:
+				err = r.(error)
+			default:
+				panic(r)
+			}
+		}
+		unwind(depth)
+		d.evaluating = false
+	}()
+	p := newParser("", lexOut)
+	expression := p.booleanExpression(scope)
+	if !p.accept("eof") {
+		p.errorf(p.pos(), "unexpected '%s'", p.token.symbol)
+	}
+	d.evaluating = true
+	return expression.visitExpression(scope), nil
+}
+
+// show describes the value of the expression text in scope.
+func (d *debugger) show(text string, scope *scope) string {
+	e, err := d.evaluate(text, scope)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	if e == nil {
+		return "nil"
+	}
+	return e.String()
+}
+
+// showScopes lists the variables of scope and of each scope enclosing it,
+// leaving out the math constants.
+func (d *debugger) showScopes(scope *scope) {
+	for depth := 0; scope != nil && scope.parent != nil; depth, scope = depth+1, scope.parent {
+		var names []string
+		for name := range scope.symbols {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("scope %d:\n", depth)
+		for _, name := range names {
+			fmt.Printf("  %s = %s\n", name, describeSymbol(scope.symbols[name].value))
+		}
+	}
+}
+
+func describeSymbol(value interface{}) string {
+	switch v := value.(type) {
+	case *expression:
+		if v == nil {
+			return "nil"
+		}
+		return v.String()
+	case *functionValue:
+		return fmt.Sprintf("fn %s", v.definition.name)
+	case *structStatement:
+		return fmt.Sprintf("struct %s", v.name)
+	case *classStatement:
+		return fmt.Sprintf("class %s", v.name)
+	case *enumStatement:
+		return fmt.Sprintf("enum %s", v.name)
+	}
+	return fmt.Sprint(value)
+}
+
+func (d *debugger) backtrace() {
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		f := d.frames[i]
+		name := "main"
+		if f.function != nil {
+			name = "fn " + f.function.name
+		}
+		if f.statement != nil {
+			fmt.Printf("#%d %s at %s\n", len(d.frames)-1-i, name, d.location(f.statement))
+		} else {
+			fmt.Printf("#%d %s\n", len(d.frames)-1-i, name)
+		}
+	}
+}
+
+// list shows the lines within context of the line at.
+func (d *debugger) list(at location, context int) {
+	var source []byte
+	for _, m := range d.modules {
+		if m.path == at.path {
+			source = m.source
+		}
+	}
+	lines := strings.Split(string(source), "\n")
+	for n := at.line - context; n <= at.line+context; n++ {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		marker := "  "
+		if n == at.line {
+			marker = "=>"
+		}
+		fmt.Printf("%s %4d  %s\n", marker, n, lines[n-1])
+	}
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// formatter writes a program back as source in canonical style: two
+// spaces of indentation, one statement per line, single spaces around
+// operators and parentheses only where precedence requires them. Comments
+// are kept before the statements they precede, or at the end of the line
+// they trail, and runs of blank lines between statements become one. Lists
+// of elements, arguments or parameters with comments among them are written
+// one item per line, keeping the comments where they were.
+type formatter struct {
+	bytes.Buffer
+	depth    int
+	comments []*comment
+	blank    map[int]bool
+	// line is the last source line written, or 0 at the start of a block.
+	line int
+	// closing is the line of the token closing the block or list being
+	// written, whose trailing comment follows that token.
+	closing int
+}
+
+func formatModule(m *module) []byte {
+	f := &formatter{comments: m.comments, blank: map[int]bool{}}
+	lines := strings.Split(string(m.source), "\n")
+	for i, line := range lines {
+		f.blank[i+1] = strings.TrimSpace(line) == ""
+	}
+	if strings.HasPrefix(lines[0], "#!") {
+		f.WriteString(strings.TrimRight(lines[0], "\r") + "\n")
+		f.line = 1
+	}
+	f.statements(m.block.statements, len(lines)+1)
+	return f.Bytes()
+}
+
+func fmtCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the file instead of standard output")
+	checkOnly := flags.Bool("check", false, "list the files that are not formatted, failing if there are any")
+	flags.Parse(args)
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	unformatted := false
+	for _, file := range files {
+		m := parseUnresolved(file, source(file))
+		formatted := formatModule(m)
+		switch {
+		case *checkOnly:
+			if !bytes.Equal(formatted, m.source) {
+				fmt.Println(file)
+				unformatted = true
+			}
+		case *write && file != "-":
+			if bytes.Equal(formatted, m.source) {
+				continue
+			}
+			if err := ioutil.WriteFile(file, formatted, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	if unformatted {
+		os.Exit(1)
+	}
+}
+
+func (f *formatter) indent() {
+	f.WriteString(strings.Repeat("  ", f.depth))
+}
+
+// startLine indents a line for the source at line, after a blank line if
+// the source has any since the last line written.
+func (f *formatter) startLine(line int) {
+	if f.line > 0 {
+		for l := f.line + 1; l < line; l++ {
+			if f.blank[l] {
+				f.WriteString("\n")
+				break
+			}
+		}
+	}
+	f.indent()
+	if line > f.line {
+		f.line = line
+	}
+}
+
+// commentsBefore writes the comments on lines before line, each on a line
+// of its own.
+func (f *formatter) commentsBefore(line int) {
+	for len(f.comments) > 0 && f.comments[0].line < line {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		f.startLine(c.line)
+		f.WriteString(c.text + "\n")
+	}
+}
+
+// trailingComment writes the comment on the last source line written, if
+// any, at the end of the line.
+func (f *formatter) trailingComment() {
+	if len(f.comments) > 0 && f.comments[0].line == f.line && f.line != f.closing {
+		f.WriteString(" " + f.comments[0].text)
+		f.comments = f.comments[1:]
+	}
+}
+
+// statements writes statements one per line, followed by the comments
+// before end, the line closing them. A comment at the end of a line trails
+// the last statement on it.
+func (f *formatter) statements(statements []statementVisitor, end int) {
+	for i, s := range statements {
+		f.commentsBefore(s.position().line)
+		f.startLine(s.position().line)
+		s.formatStatement(f)
+		if i == len(statements)-1 || statements[i+1].position().line != f.line {
+			f.trailingComment()
+		}
+		f.WriteString("\n")
+	}
+	f.commentsBefore(end)
+}
+
+// block writes b between braces, indented one level deeper.
+func (f *formatter) block(b *block) {
+	f.WriteString("{\n")
+	f.depth++
+	f.line = 0
+	closing := f.closing
+	f.closing = b.end.line
+	f.statements(b.statements, b.end.line)
+	f.closing = closing
+	f.depth--
+	f.indent()
+	f.WriteString("}")
+	f.line = b.end.line
+}
+
+// items writes the items starting at starts, separated by commas, using
+// item to write each. end is where the token closing them starts. If
+// comments fall among the items, they are written one per line, as lines
+// does.
+func (f *formatter) items(starts []pos, end pos, expressions []expressionVisitor, item func(i int)) {
+	if f.commentsAmong(end, expressions) {
+		f.lines(starts, end, item)
+		return
+	}
+	for i := range starts {
+		if i != 0 {
+			f.WriteString(", ")
+		}
+		item(i)
+	}
+}
+
+// commentsAmong reports whether comments come before end, where the token
+// closing a list starts, other than those nested in its expressions.
+func (f *formatter) commentsAmong(end pos, expressions []expressionVisitor) bool {
+	for _, c := range f.comments {
+		if !c.before(end) {
+			break
+		}
+		if !nestedIn(expressions, c.pos) {
+			return true
+		}
+	}
+	return false
+}
+
+func nestedIn(expressions []expressionVisitor, p pos) bool {
+	for _, e := range expressions {
+		if nested(e, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// nested reports whether p falls between the tokens opening and closing
+// the arguments, elements or arms of e or of an expression within it,
+// which are written with the comments there.
+func nested(e expressionVisitor, p pos) bool {
+	within := func(start, end pos) bool {
+		return start.before(p) && p.before(end)
+	}
+	switch e := e.(type) {
+	case *booleanExpression:
+		return nested(e.left, p) || e.right != nil && nested(e.right, p)
+	case *logicalOperand:
+		return nested(e.left, p) || e.right != nil && nested(e.right, p)
+	case *term:
+		return nested(e.left, p) || e.right != nil && nested(e.right, p)
+	case *logicalNotExpression:
+		return nested(e.booleanExpression, p)
+	case *fieldExpression:
+		return nested(e.object, p)
+	case *interpolationExpression:
+		return nestedIn(e.expressions, p)
+	case *callExpression:
+		return within(e.pos, e.end) || e.receiver != nil && nested(e.receiver, p)
+	case *listLiteral:
+		return within(e.pos, e.end)
+	case *mapLiteral:
+		return within(e.pos, e.end)
+	case *structLiteral:
+		return within(e.pos, e.end)
+	case *variantExpression:
+		return within(e.pos, e.end)
+	case *matchExpression:
+		return within(e.pos, e.end)
+	}
+	return false
+}
+
+// lines writes the items starting at starts one per line, indented one
+// level deeper and separated by commas, with the comments before end, where
+// the token closing them starts.
+func (f *formatter) lines(starts []pos, end pos, item func(i int)) {
+	f.WriteString("\n")
+	f.depth++
+	closing := f.closing
+	f.closing = end.line
+	for i, start := range starts {
+		f.commentsBefore(start.line)
+		f.startLine(start.line)
+		item(i)
+		if i != len(starts)-1 {
+			f.WriteString(",")
+		}
+		f.trailingComment()
+		f.WriteString("\n")
+	}
+	f.commentsBefore(end.line)
+	f.closing = closing
+	f.depth--
+	f.indent()
+	f.line = end.line
+}
+
+// precedence returns how tightly e binds its operands, from 1 for or to 7
+// for primaries.
+func precedence(e expressionVisitor) int {
+	switch e := e.(type) {
+	case *booleanExpression:
+		switch e.operator {
+		case "or":
+			return 1
+		case "and":
+			return 2
+		}
+		return 3
+	case *logicalOperand:
+		return 4
+	case *term:
+		return 5
+	case *logicalNotExpression:
+		return 6
+	}
+	return 7
+}
+
+// operand writes e, parenthesized if it binds less tightly than min.
+func (f *formatter) operand(e expressionVisitor, min int) {
+	if precedence(e) < min {
+		f.WriteString("(")
+		e.formatExpression(f)
+		f.WriteString(")")
+		return
+	}
+	e.formatExpression(f)
+}
+
+func (f *formatter) binary(left expressionVisitor, operator string, right expressionVisitor, min int) {
+	f.operand(left, min)
+	f.WriteString(" " + operator + " ")
+	f.operand(right, min+1)
+}
+
+// expressions writes expressions separated by commas, end being where the
+// token closing them starts.
+func (f *formatter) expressions(expressions []expressionVisitor, end pos) {
+	f.items(positions(expressions), end, expressions, func(i int) {
+		expressions[i].formatExpression(f)
+	})
+}
+
+func positions(expressions []expressionVisitor) []pos {
+	var starts []pos
+	for _, e := range expressions {
+		starts = append(starts, e.position())
+	}
+	return starts
+}
+
+func (f *formatter) typed(name string, typeName string) {
+	f.WriteString(name)
+	if typeName != "" {
+		f.WriteString(": " + typeName)
+	}
+}
+
+// quote writes s as the contents of a string literal.
+func (f *formatter) quote(s string) {
+	for i, c := range s {
+		switch c {
+		case '\n':
+			f.WriteString(`\n`)
+		case '\t':
+			f.WriteString(`\t`)
+		case '\r':
+			f.WriteString(`\r`)
+		case '"', '\\':
+			f.WriteString(`\` + string(c))
+		case '$':
+			if strings.HasPrefix(s[i+1:], "{") {
+				f.WriteString(`\`)
+			}
+			f.WriteRune(c)
+		default:
+			f.WriteRune(c)
+		}
+	}
+}
+
+func (d *declarationStatement) formatStatement(f *formatter) {
+	f.WriteString("var ")
+	f.typed(d.id, d.typeName)
+	f.WriteString(" = ")
+	d.expression.formatExpression(f)
+	f.WriteString(";")
+}
+
+func (a *assignmentStatement) formatStatement(f *formatter) {
+	f.WriteString(a.id + " = ")
+	a.expression.formatExpression(f)
+	f.WriteString(";")
+}
+
+func (f *fieldAssignmentStatement) formatStatement(fm *formatter) {
+	fm.operand(f.object, 7)
+	fm.WriteString("." + f.field + " = ")
+	f.expression.formatExpression(fm)
+	fm.WriteString(";")
+}
+
+func (i *ifStatement) formatStatement(f *formatter) {
+	f.WriteString("if ")
+	i.booleanExpression.formatExpression(f)
+	f.WriteString(" ")
+	f.block(i.block)
+}
+
+func (w *whileStatement) formatStatement(f *formatter) {
+	if w.label != "" {
+		f.WriteString(w.label + ": ")
+	}
+	f.WriteString("while ")
+	w.booleanExpression.formatExpression(f)
+	f.WriteString(" ")
+	f.block(w.block)
+}
+
+func (fs *forStatement) formatStatement(f *formatter) {
+	if fs.label != "" {
+		f.WriteString(fs.label + ": ")
+	}
+	f.WriteString("for ")
+	if fs.key != "" {
+		f.WriteString(fs.key + ", ")
+	}
+	f.WriteString(fs.value + " in ")
+	fs.collection.formatExpression(f)
+	f.WriteString(" ")
+	f.block(fs.block)
+}
+
+func (b *breakStatement) formatStatement(f *formatter) {
+	if b.label != "" {
+		f.WriteString("break " + b.label + ";")
+		return
+	}
+	f.WriteString("break;")
+}
+
+func (c *continueStatement) formatStatement(f *formatter) {
+	if c.label != "" {
+		f.WriteString("continue " + c.label + ";")
+		return
+	}
+	f.WriteString("continue;")
+}
+
+func (fs *functionStatement) formatStatement(f *formatter) {
+	f.WriteString("fn " + fs.name + "(")
+	f.items(fs.parameterPositions, fs.parametersEnd, nil, func(i int) {
+		f.typed(fs.parameters[i], fs.parameterTypes[i])
+	})
+	f.WriteString(")")
+	if fs.returnType != "" {
+		f.WriteString(": " + fs.returnType)
+	}
+	f.WriteString(" ")
+	f.block(fs.block)
+}
+
+func (r *returnStatement) formatStatement(f *formatter) {
+	if r.expression == nil {
+		f.WriteString("return;")
+		return
+	}
+	f.WriteString("return ")
+	r.expression.formatExpression(f)
+	f.WriteString(";")
+}
+
+func (s *structStatement) formatStatement(f *formatter) {
+	f.WriteString("struct " + s.name + " {")
+	for i, field := range s.fields {
+		if i != 0 {
+			f.WriteString(",")
+		}
+		f.WriteString(" ")
+		f.typed(field, s.fieldTypes[i])
+	}
+	if len(s.fields) > 0 {
+		f.WriteString(" ")
+	}
+	f.WriteString("}")
+}
+
+// formatStatement writes the fields and methods of c in the order of the
+// source.
+func (c *classStatement) formatStatement(f *formatter) {
+	var members []statementVisitor
+	fields, methods := c.fields, c.methods
+	for len(fields) > 0 || len(methods) > 0 {
+		if len(methods) == 0 || len(fields) > 0 && fields[0].before(methods[0].pos) {
+			members, fields = append(members, fields[0]), fields[1:]
+		} else {
+			members, methods = append(members, methods[0]), methods[1:]
+		}
+	}
+	f.WriteString("class " + c.name)
+	if c.parent != "" {
+		f.WriteString(" : " + c.parent)
+	}
+	f.WriteString(" {\n")
+	f.depth++
+	f.line = 0
+	closing := f.closing
+	f.closing = c.end.line
+	f.statements(members, c.end.line)
+	f.closing = closing
+	f.depth--
+	f.indent()
+	f.WriteString("}")
+	f.line = c.end.line
+}
+
+func (e *enumStatement) formatStatement(f *formatter) {
+	f.WriteString("enum " + e.name + " {")
+	var starts []pos
+	for _, v := range e.variants {
+		starts = append(starts, v.pos)
+	}
+	f.lines(starts, e.end, func(i int) {
+		f.variant(e.variants[i])
+	})
+	f.WriteString("}")
+}
+
+func (f *formatter) variant(v *enumVariant) {
+	f.WriteString(v.name)
+	if len(v.fields) > 0 {
+		f.WriteString("(")
+		for i, field := range v.fields {
+			if i != 0 {
+				f.WriteString(", ")
+			}
+			f.typed(field, v.fieldTypes[i])
+		}
+		f.WriteString(")")
+	}
+}
+
+func (i *importStatement) formatStatement(f *formatter) {
+	f.WriteString(`import "`)
+	f.quote(i.path)
+	f.WriteString(`";`)
+}
+
+func (t *testStatement) formatStatement(f *formatter) {
+	f.WriteString(`test "`)
+	f.quote(t.name)
+	f.WriteString(`" `)
+	f.block(t.block)
+}
+
+func (e *exportStatement) formatStatement(f *formatter) {
+	f.WriteString("export ")
+	e.statement.formatStatement(f)
+}
+
+func (b *block) formatStatement(f *formatter) {
+	f.block(b)
+}
+
+func (c *callExpression) formatStatement(f *formatter) {
+	c.formatExpression(f)
+	f.WriteString(";")
+}
+
+func (m *matchExpression) formatStatement(f *formatter) {
+	m.formatExpression(f)
+}
+
+func (b *booleanExpression) formatExpression(f *formatter) {
+	if b.operator == "or" || b.operator == "and" {
+		f.binary(b.left, b.operator, b.right, precedence(b))
+		return
+	}
+	f.operand(b.left, 4)
+	f.WriteString(" " + b.operator + " ")
+	f.operand(b.right, 4)
+}
+
+func (l *logicalOperand) formatExpression(f *formatter) {
+	f.binary(l.left, l.operator, l.right, 4)
+}
+
+func (t *term) formatExpression(f *formatter) {
+	f.binary(t.left, t.operator, t.right, 5)
+}
+
+func (l *logicalNotExpression) formatExpression(f *formatter) {
+	f.WriteString("not ")
+	f.operand(l.booleanExpression, 6)
+}
+
+func (c *callExpression) formatExpression(f *formatter) {
+	if c.receiver != nil {
+		f.operand(c.receiver, 7)
+		f.WriteString(".")
+	}
+	f.WriteString(c.name + "(")
+	f.expressions(c.arguments, c.end)
+	f.WriteString(")")
+}
+
+func (i *identifier) formatExpression(f *formatter) {
+	f.WriteString(i.value)
+}
+
+func (n *numberLiteral) formatExpression(f *formatter) {
+	f.WriteString(n.value)
+}
+
+func (s *stringLiteral) formatExpression(f *formatter) {
+	f.WriteString(`"`)
+	f.quote(s.value)
+	f.WriteString(`"`)
+}
+
+func (i *interpolationExpression) formatExpression(f *formatter) {
+	f.WriteString(`"`)
+	for j, e := range i.expressions {
+		f.quote(i.segments[j])
+		f.WriteString("${")
+		e.formatExpression(f)
+		f.WriteString("}")
+	}
+	f.quote(i.segments[len(i.segments)-1])
+	f.WriteString(`"`)
+}
+
+func (b *booleanLiteral) formatExpression(f *formatter) {
+	fmt.Fprintf(f, "%t", b.value)
+}
+
+func (l *listLiteral) formatExpression(f *formatter) {
+	f.WriteString("[")
+	f.expressions(l.elements, l.end)
+	f.WriteString("]")
+}
+
+func (m *mapLiteral) formatExpression(f *formatter) {
+	f.WriteString("{")
+	f.items(positions(m.keys), m.end, append(append([]expressionVisitor{}, m.keys...), m.values...), func(i int) {
+		m.keys[i].formatExpression(f)
+		f.WriteString(": ")
+		m.values[i].formatExpression(f)
+	})
+	f.WriteString("}")
+}
+
+func (s *structLiteral) formatExpression(f *formatter) {
+	f.WriteString(s.name + " {")
+	if f.commentsAmong(s.end, s.values) {
+		f.lines(positions(s.values), s.end, func(i int) {
+			f.WriteString(s.fields[i] + ": ")
+			s.values[i].formatExpression(f)
+		})
+		f.WriteString("}")
+		return
+	}
+	for i, field := range s.fields {
+		if i != 0 {
+			f.WriteString(",")
+		}
+		f.WriteString(" " + field + ": ")
+		s.values[i].formatExpression(f)
+	}
+	if len(s.fields) > 0 {
+		f.WriteString(" ")
+	}
+	f.WriteString("}")
+}
+
+func (fe *fieldExpression) formatExpression(f *formatter) {
+	f.operand(fe.object, 7)
+	f.WriteString("." + fe.field)
+}
+
+func (v *variantExpression) formatExpression(f *formatter) {
+	f.WriteString(v.enum + "." + v.variant)
+	if len(v.arguments) > 0 {
+		f.WriteString("(")
+		f.expressions(v.arguments, v.end)
+		f.WriteString(")")
+	}
+}
+
+func (m *matchExpression) formatExpression(f *formatter) {
+	f.WriteString("match ")
+	m.subject.formatExpression(f)
+	f.WriteString(" {")
+	var starts []pos
+	for _, arm := range m.arms {
+		starts = append(starts, arm.pos)
+	}
+	f.lines(starts, m.end, func(i int) {
+		arm := m.arms[i]
+		arm.pattern.formatPattern(f)
+		if arm.guard != nil {
+			f.WriteString(" if ")
+			arm.guard.formatExpression(f)
+		}
+		f.WriteString(" => ")
+		arm.body.formatExpression(f)
+	})
+	f.WriteString("}")
+}
+
+func (s *superExpression) formatExpression(f *formatter) {
+	f.WriteString("super")
+}
+
+func (l *literalPattern) formatPattern(f *formatter) {
+	l.literal.formatExpression(f)
+}
+
+func (b *bindingPattern) formatPattern(f *formatter) {
+	f.WriteString(b.name)
+}
+
+func (w *wildcardPattern) formatPattern(f *formatter) {
+	f.WriteString("_")
+}
+
+func (v *variantPattern) formatPattern(f *formatter) {
+	f.WriteString(v.enum + "." + v.variant)
+	if len(v.patterns) > 0 {
+		f.WriteString("(")
+		for i, pattern := range v.patterns {
+			if i != 0 {
+				f.WriteString(", ")
+			}
+			pattern.formatPattern(f)
+		}
+		f.WriteString(")")
+	}
+}
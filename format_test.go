@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// TestFmt checks that fmt leaves the programs of test/good/fmt unchanged,
+// and test/bad/fmt/2.txt, whose import cannot be found.
+func TestFmt(t *testing.T) {
+	files, err := filepath.Glob("test/good/fmt/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fmt programs")
+	}
+	for _, file := range append(files, "test/bad/fmt/2.txt") {
+		reset()
+		m := parseUnresolved(file, source(file))
+		if got := formatModule(m); !bytes.Equal(got, m.source) {
+			t.Errorf("%s:\ngot  %s\nwant %s", file, got, m.source)
+		}
+	}
+}
@@ -7,37 +7,107 @@ block
 statement
   : declaration
   | ifStatement
+  | labeledStatement
   | whileStatement
+  | forStatement
+  | breakStatement
+  | continueStatement
   | functionStatement
   | returnStatement
+  | structStatement
+  | classStatement
+  | enumStatement
+  | matchExpression
+  | importStatement
+  | exportStatement
+  | testStatement
   | assignment
+  | fieldAssignment
   | callExpression
+  | atom ';'
   ;
 
 declaration
-  : 'var' Id '=' booleanExpression ';'
+  : 'var' Id typeAnnotation? '=' booleanExpression ';'
   ;
 
 ifStatement
   : 'if' booleanExpression '{' block '}'
   ;
 
+labeledStatement
+  : Id ':' (whileStatement | forStatement)
+  ;
+
 whileStatement
   : 'while' booleanExpression '{' block '}'
   ;
 
+forStatement
+  : 'for' Id (',' Id)? 'in' booleanExpression '{' block '}'
+  ;
+
+breakStatement
+  : 'break' Id? ';'
+  ;
+
+continueStatement
+  : 'continue' Id? ';'
+  ;
+
 functionStatement
-  : 'fn' Id '(' (Id (',' Id)?)? ')' '{' block '}'
+  : 'fn' Id '(' (parameter (',' parameter)*)? ')' typeAnnotation? '{' block '}'
+  ;
+
+parameter
+  : Id typeAnnotation?
+  ;
+
+typeAnnotation
+  : ':' Id
   ;
 
 returnStatement
-  : 'return' booleanExpression ';'
+  : 'return' booleanExpression? ';'
+  ;
+
+structStatement
+  : 'struct' Id '{' (Id typeAnnotation? (',' Id typeAnnotation?)*)? '}'
+  ;
+
+classStatement
+  : 'class' Id (':' Id)? '{' (declaration | functionStatement)* '}'
+  ;
+
+enumStatement
+  : 'enum' Id '{' (variant (',' variant)*)? '}'
+  ;
+
+variant
+  : Id ('(' (Id typeAnnotation? (',' Id typeAnnotation?)*)? ')')?
+  ;
+
+importStatement
+  : 'import' String ';'
+  ;
+
+exportStatement
+  : 'export' (declaration | functionStatement | structStatement | classStatement | enumStatement)
+  ;
+
+// Id is test, which is not a keyword: it names a test only before a String.
+testStatement
+  : Id String '{' block '}'
   ;
 
 assignment
   : Id '=' booleanExpression ';'
   ;
 
+fieldAssignment
+  : atom '.' Id '=' booleanExpression ';'
+  ;
+
 callExpression
   : Id '(' (booleanExpression (',' booleanExpression)?)? ')'
   ;
@@ -68,14 +138,73 @@ logicalNotExpression
   ;
 
 atom
+  : primary ('.' Id ('(' (booleanExpression (',' booleanExpression)*)? ')')?)*
+  ;
+
+primary
   : Id
+  | callExpression
+  | structLiteral
+  | superCall
+  | variantExpression
+  | matchExpression
   | Number
   | String
+  | interpolation
   | ('true'|'false')
   | '(' booleanExpression ')'
+  | listLiteral
+  | mapLiteral
+  ;
+
+superCall
+  : 'super' '.' Id '(' (booleanExpression (',' booleanExpression)*)? ')'
+  ;
+
+variantExpression
+  : Id '.' Id ('(' (booleanExpression (',' booleanExpression)*)? ')')?
+  ;
+
+matchExpression
+  : 'match' booleanExpression '{' (matchArm (',' matchArm)*)? '}'
+  ;
+
+matchArm
+  : pattern ('if' booleanExpression)? '=>' booleanExpression
+  ;
+
+pattern
+  : '_'
+  | Id '.' Id ('(' (pattern (',' pattern)*)? ')')?
+  | Id
+  | Number
+  | String
+  | ('true'|'false')
+  ;
+
+interpolation
+  : InterpolationStart booleanExpression (InterpolationMiddle booleanExpression)* InterpolationEnd
+  ;
+
+structLiteral
+  : Id '{' (Id ':' booleanExpression (',' Id ':' booleanExpression)*)? '}'
+  ;
+
+listLiteral
+  : '[' (booleanExpression (',' booleanExpression)*)? ']'
+  ;
+
+mapLiteral
+  : '{' (booleanExpression ':' booleanExpression (',' booleanExpression ':' booleanExpression)*)? '}'
   ;
 
 Id: [a-zA-Z_][a-zA-Z_0-9]*;
-Number: [0-9]+;
-String: '"' (~["\r\n] | '\\"')* '"';
+Number: [0-9]+ ('.' [0-9]+)?;
+String: '"' StringCharacter* '"';
+InterpolationStart: '"' StringCharacter* '${';
+InterpolationMiddle: '}' StringCharacter* '${';
+InterpolationEnd: '}' StringCharacter* '"';
+fragment StringCharacter: ~["\\$\r\n] | '\\' . | '$' ~'{';
 Whitespace: [ \t\r\n]+ -> skip;
+Shebang: '#!' ~[\r\n]* -> skip;
+Comment: '//' ~[\r\n]* -> skip;
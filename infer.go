@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type (
+	// inferredType is a type variable or a type operator such as number,
+	// list(a) or fn(a, b, result) in the whole-program inference pass.
+	inferredType interface{}
+
+	typeVariable struct {
+		id       int
+		instance inferredType
+	}
+
+	typeOperator struct {
+		name string
+		args []inferredType
+	}
+
+	// typeScheme is a polymorphic type: variables are instantiated with
+	// fresh type variables every time the scheme is used.
+	typeScheme struct {
+		variables []*typeVariable
+		t         inferredType
+	}
+
+	inferrer struct {
+		root       *scope
+		scope      *scope
+		functions  map[string]*functionStatement
+		schemes    map[*functionStatement]*typeScheme
+		structs    map[string]map[string]inferredType
+		classes    map[string]*classStatement
+		methods    map[*functionStatement]*classStatement
+		enums      map[string]*enumStatement
+		variants   map[*enumVariant][]inferredType
+		inProgress map[*functionStatement]inferredType
+		modules    map[*module]*scope
+		prefix     string
+		order      []*functionStatement
+		globals    []string
+		returnType inferredType
+		returned   bool
+		variables  int
+		errors     []string
+		// types holds the types of the variables declared and used in the
+		// inferred module, by position.
+		types map[pos]inferredType
+	}
+)
+
+var voidType = &typeOperator{"void", nil}
+
+func infer(b *block) *inferrer {
+	in := &inferrer{}
+	in.root = in.globalScope()
+	in.scope = in.root
+	in.functions = map[string]*functionStatement{}
+	in.schemes = map[*functionStatement]*typeScheme{}
+	in.structs = map[string]map[string]inferredType{}
+	in.classes = map[string]*classStatement{}
+	in.methods = map[*functionStatement]*classStatement{}
+	in.enums = map[string]*enumStatement{}
+	in.variants = map[*enumVariant][]inferredType{}
+	in.inProgress = map[*functionStatement]inferredType{}
+	in.modules = map[*module]*scope{}
+	in.types = map[pos]inferredType{}
+	collectFunctions(b, in.functions)
+	b.inferStatement(in)
+	return in
+}
+
+func (in *inferrer) globalScope() *scope {
+	return newScope(constantScope(func(e *expression) interface{} { return in.typeFor(e.typeValue) }))
+}
+
+func (in *inferrer) errorf(pos pos, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	in.errors = append(in.errors, fmt.Sprintf("%s%s at line %d, column %d", in.prefix, message, pos.line, pos.column))
+}
+
+// record notes that the variable at pos has type t, unless pos is in an
+// imported module.
+func (in *inferrer) record(pos pos, t inferredType) {
+	if in.prefix == "" {
+		in.types[pos] = t
+	}
+}
+
+func (in *inferrer) fresh() *typeVariable {
+	in.variables++
+	return &typeVariable{in.variables, nil}
+}
+
+func (in *inferrer) typeFor(t expressionType) inferredType {
+	switch t {
+	case 0:
+		return in.fresh()
+	case listType:
+		return &typeOperator{"list", []inferredType{in.fresh()}}
+	case mapType:
+		return &typeOperator{"map", []inferredType{in.fresh(), in.fresh()}}
+	}
+	return &typeOperator{types[t], nil}
+}
+
+func (in *inferrer) annotation(typeName string) inferredType {
+	if _, ok := in.structs[typeName]; ok {
+		return &typeOperator{typeName, nil}
+	}
+	if _, ok := in.classes[typeName]; ok {
+		return &typeOperator{typeName, nil}
+	}
+	if _, ok := in.enums[typeName]; ok {
+		return &typeOperator{typeName, nil}
+	}
+	return in.typeFor(typeNamed(typeName))
+}
+
+func prune(t inferredType) inferredType {
+	if v, ok := t.(*typeVariable); ok && v.instance != nil {
+		v.instance = prune(v.instance)
+		return v.instance
+	}
+	return t
+}
+
+func occursIn(v *typeVariable, t inferredType) bool {
+	switch t := prune(t).(type) {
+	case *typeVariable:
+		return v == t
+	case *typeOperator:
+		for _, arg := range t.args {
+			if occursIn(v, arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unify makes actual and expected the same type, reporting a mismatch at
+// pos when that is impossible.
+func (in *inferrer) unify(pos pos, expected inferredType, actual inferredType) {
+	expected, actual = prune(expected), prune(actual)
+	if v, ok := expected.(*typeVariable); ok {
+		in.bind(pos, v, actual)
+		return
+	}
+	if v, ok := actual.(*typeVariable); ok {
+		in.bind(pos, v, expected)
+		return
+	}
+	e, a := expected.(*typeOperator), actual.(*typeOperator)
+	if in.subclass(a.name, e.name) || in.subclass(e.name, a.name) {
+		return
+	}
+	if e.name != a.name || len(e.args) != len(a.args) {
+		names := map[*typeVariable]string{}
+		in.errorf(pos, "type mismatch: %s != %s", typeString(a, names), typeString(e, names))
+		return
+	}
+	for i := range e.args {
+		in.unify(pos, e.args[i], a.args[i])
+	}
+}
+
+// subclass reports whether name is a class derived from parent. Values of
+// related classes unify, as objects of a class may stand in for objects of
+// its ancestors.
+func (in *inferrer) subclass(name string, parent string) bool {
+	class, ok := in.classes[name]
+	return ok && name != parent && instanceOf(in.classes, class, parent)
+}
+
+func (in *inferrer) parentOf(class *classStatement) *classStatement {
+	return in.classes[class.parent]
+}
+
+func (in *inferrer) bind(pos pos, v *typeVariable, t inferredType) {
+	if v == t {
+		return
+	}
+	if occursIn(v, t) {
+		names := map[*typeVariable]string{}
+		in.errorf(pos, "recursive type: %s occurs in %s", typeString(v, names), typeString(t, names))
+		return
+	}
+	v.instance = t
+}
+
+func freeVariables(t inferredType, free map[*typeVariable]bool) {
+	switch t := prune(t).(type) {
+	case *typeVariable:
+		free[t] = true
+	case *typeOperator:
+		for _, arg := range t.args {
+			freeVariables(arg, free)
+		}
+	}
+}
+
+func (in *inferrer) generalize(t inferredType) *typeScheme {
+	bound := map[*typeVariable]bool{}
+	for scope := in.scope; scope != nil; scope = scope.parent {
+		for _, symbol := range scope.symbols {
+			freeVariables(symbol.value.(inferredType), bound)
+		}
+	}
+	for _, t := range in.inProgress {
+		freeVariables(t, bound)
+	}
+	free := map[*typeVariable]bool{}
+	freeVariables(t, free)
+	scheme := &typeScheme{nil, t}
+	for v := range free {
+		if !bound[v] {
+			scheme.variables = append(scheme.variables, v)
+		}
+	}
+	return scheme
+}
+
+func (in *inferrer) instantiate(s *typeScheme) inferredType {
+	fresh := map[*typeVariable]inferredType{}
+	for _, v := range s.variables {
+		fresh[v] = in.fresh()
+	}
+	var copy func(t inferredType) inferredType
+	copy = func(t inferredType) inferredType {
+		switch t := prune(t).(type) {
+		case *typeVariable:
+			if v, ok := fresh[t]; ok {
+				return v
+			}
+			return t
+		case *typeOperator:
+			var args []inferredType
+			for _, arg := range t.args {
+				args = append(args, copy(arg))
+			}
+			return &typeOperator{t.name, args}
+		}
+		return t
+	}
+	return copy(s.t)
+}
+
+func (in *inferrer) inferFunction(f *functionStatement, parent *scope) *typeScheme {
+	if s, ok := in.schemes[f]; ok {
+		return s
+	}
+	var args []inferredType
+	scope := newScope(parent)
+	for i, p := range f.parameters {
+		t := in.fresh()
+		if f.parameterTypes[i] != "" {
+			in.unify(f.pos, in.annotation(f.parameterTypes[i]), t)
+		}
+		scope.declare(p, t)
+		args = append(args, t)
+	}
+	result := in.fresh()
+	if f.returnType != "" {
+		in.unify(f.pos, in.annotation(f.returnType), result)
+	}
+	t := &typeOperator{"fn", append(args, result)}
+	in.inProgress[f] = t
+
+	outer, returnType, returned := in.scope, in.returnType, in.returned
+	in.scope, in.returnType, in.returned = scope, result, false
+	f.block.inferStatement(in)
+	if !in.returned {
+		in.unify(f.pos, result, voidType)
+	}
+	in.scope, in.returnType, in.returned = outer, returnType, returned
+
+	delete(in.inProgress, f)
+	s := in.generalize(t)
+	in.schemes[f] = s
+	return s
+}
+
+func (in *inferrer) inferBlock(b *block, scope *scope) {
+	outer := in.scope
+	in.scope = scope
+	b.inferStatement(in)
+	in.scope = outer
+}
+
+// signature describes the inferred type of f, naming its type variables
+// a, b, c and so on.
+func (in *inferrer) signature(f *functionStatement) string {
+	var buf bytes.Buffer
+	names := map[*typeVariable]string{}
+	t := prune(in.schemes[f].t).(*typeOperator)
+	for i, p := range f.parameters {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(fmt.Sprintf("%s: %s", p, typeString(t.args[i], names)))
+	}
+	name := f.name
+	if class, ok := in.methods[f]; ok {
+		name = class.name + "." + name
+	}
+	return fmt.Sprintf("fn %s(%s): %s", name, buf.String(), typeString(t.args[len(t.args)-1], names))
+}
+
+func typeString(t inferredType, names map[*typeVariable]string) string {
+	switch t := prune(t).(type) {
+	case *typeVariable:
+		if _, ok := names[t]; !ok {
+			names[t] = string(rune('a' + len(names)%26))
+		}
+		return names[t]
+	case *typeOperator:
+		if len(t.args) == 0 {
+			return t.name
+		}
+		var buf bytes.Buffer
+		for i, arg := range t.args {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(typeString(arg, names))
+		}
+		return fmt.Sprintf("%s(%s)", t.name, buf.String())
+	}
+	return "?"
+}
+
+func (d *declarationStatement) inferStatement(in *inferrer) {
+	t := d.expression.inferExpression(in)
+	if d.typeName != "" {
+		in.unify(d.expression.position(), in.annotation(d.typeName), t)
+	}
+	if in.scope == in.root {
+		in.globals = append(in.globals, d.id)
+	}
+	in.record(d.pos, t)
+	in.scope.declare(d.id, t)
+}
+
+func (a *assignmentStatement) inferStatement(in *inferrer) {
+	t := a.expression.inferExpression(in)
+	if symbol := in.scope.resolve(a.id); symbol != nil {
+		in.unify(a.expression.position(), symbol.value.(inferredType), t)
+	}
+}
+
+func (i *ifStatement) inferStatement(in *inferrer) {
+	in.unify(i.booleanExpression.position(), in.typeFor(booleanType), i.booleanExpression.inferExpression(in))
+	in.inferBlock(i.block, newScope(in.scope))
+}
+
+func (w *whileStatement) inferStatement(in *inferrer) {
+	in.unify(w.booleanExpression.position(), in.typeFor(booleanType), w.booleanExpression.inferExpression(in))
+	in.inferBlock(w.block, newScope(in.scope))
+}
+
+func (f *forStatement) inferStatement(in *inferrer) {
+	var key, value inferredType = in.typeFor(numberType), in.fresh()
+	switch t := prune(f.collection.inferExpression(in)).(type) {
+	case *typeOperator:
+		switch t.name {
+		case "list":
+			value = t.args[0]
+		case "map":
+			key, value = t.args[0], t.args[1]
+			if f.key == "" {
+				value = key
+			}
+		case "range":
+			value = in.typeFor(numberType)
+		case "string":
+			value = in.typeFor(stringType)
+		default:
+			if class, ok := in.classes[t.name]; ok {
+				if next, owner := methodIn(in.parentOf, class, "next"); next != nil {
+					fn := prune(in.methodType(owner, next)).(*typeOperator)
+					value = fn.args[len(fn.args)-1]
+					break
+				}
+			}
+			in.errorf(f.collection.position(), "cannot iterate over %s", typeString(t, map[*typeVariable]string{}))
+		}
+	}
+	scope := newScope(in.scope)
+	if f.key != "" {
+		scope.declare(f.key, key)
+	}
+	scope.declare(f.value, value)
+	in.inferBlock(f.block, scope)
+}
+
+func (b *breakStatement) inferStatement(in *inferrer) {
+}
+
+func (c *continueStatement) inferStatement(in *inferrer) {
+}
+
+func (f *functionStatement) inferStatement(in *inferrer) {
+	in.order = append(in.order, f)
+	in.inferFunction(f, in.scope)
+}
+
+func (r *returnStatement) inferStatement(in *inferrer) {
+	in.returned = true
+	if r.expression == nil {
+		in.unify(r.pos, in.returnType, voidType)
+		return
+	}
+	in.unify(r.expression.position(), in.returnType, r.expression.inferExpression(in))
+}
+
+func (b *block) inferStatement(in *inferrer) {
+	for _, s := range b.statements {
+		s.inferStatement(in)
+	}
+}
+
+func (c *callExpression) inferStatement(in *inferrer) {
+	c.inferExpression(in)
+}
+
+func (c *callExpression) inferExpression(in *inferrer) inferredType {
+	var args []inferredType
+	for _, arg := range c.arguments {
+		args = append(args, arg.inferExpression(in))
+	}
+	if c.receiver != nil {
+		return c.inferMethod(in, args)
+	}
+	if class, ok := in.classes[c.name]; ok {
+		if init, owner := methodIn(in.parentOf, class, "init"); init != nil {
+			in.apply(c, init, in.methodType(owner, init), args)
+		} else if len(args) != 0 {
+			in.errorf(c.pos, "class %s expects 0 arguments, got %d", class.name, len(args))
+		}
+		return &typeOperator{class.name, nil}
+	}
+	f, ok := in.functions[c.name]
+	if !ok {
+		t, ok := builtinTypes[c.name]
+		if !ok {
+			in.errorf(c.pos, "could not find fn: '%s'", c.name)
+		}
+		return in.typeFor(t)
+	}
+	t, ok := in.inProgress[f]
+	if !ok {
+		t = in.instantiate(in.inferFunction(f, in.root))
+	}
+	return in.apply(c, f, t, args)
+}
+
+func (c *callExpression) inferMethod(in *inferrer, args []inferredType) inferredType {
+	var class *classStatement
+	if s, ok := c.receiver.(*superExpression); ok {
+		class = in.classes[in.classes[s.class].parent]
+	} else {
+		switch t := prune(c.receiver.inferExpression(in)).(type) {
+		case *typeVariable:
+			return in.fresh()
+		case *typeOperator:
+			class = in.classes[t.name]
+			if class == nil {
+				in.errorf(c.pos, "cannot call method '%s' on %s", c.name, typeString(t, map[*typeVariable]string{}))
+				return in.fresh()
+			}
+		}
+	}
+	m, owner := methodIn(in.parentOf, class, c.name)
+	if m == nil {
+		in.errorf(c.pos, "unrecognized method '%s' in class %s", c.name, class.name)
+		return in.fresh()
+	}
+	return in.apply(c, m, in.methodType(owner, m), args)
+}
+
+// methodType infers the type of method m of class, in which self has the
+// type of class.
+func (in *inferrer) methodType(class *classStatement, m *functionStatement) inferredType {
+	if t, ok := in.inProgress[m]; ok {
+		return t
+	}
+	scope := newScope(in.root)
+	scope.declare("self", &typeOperator{class.name, nil})
+	return in.instantiate(in.inferFunction(m, scope))
+}
+
+// apply infers the result of calling f, whose type is t, with arguments
+// of types args.
+func (in *inferrer) apply(c *callExpression, f *functionStatement, t inferredType, args []inferredType) inferredType {
+	fn := prune(t).(*typeOperator)
+	if len(fn.args)-1 != len(args) {
+		in.errorf(c.pos, "fn '%s' expects %d arguments, got %d", f.name, len(fn.args)-1, len(args))
+		return fn.args[len(fn.args)-1]
+	}
+	for i, arg := range args {
+		in.unify(c.arguments[i].position(), fn.args[i], arg)
+	}
+	return fn.args[len(fn.args)-1]
+}
+
+func (b *booleanExpression) inferExpression(in *inferrer) inferredType {
+	left := b.left.inferExpression(in)
+	right := b.right.inferExpression(in)
+	switch b.operator {
+	case "and", "or":
+		in.unify(b.left.position(), in.typeFor(booleanType), left)
+		in.unify(b.right.position(), in.typeFor(booleanType), right)
+	default:
+		in.unify(b.right.position(), left, right)
+	}
+	return in.typeFor(booleanType)
+}
+
+func (l *logicalOperand) inferExpression(in *inferrer) inferredType {
+	in.unify(l.left.position(), in.typeFor(numberType), l.left.inferExpression(in))
+	in.unify(l.right.position(), in.typeFor(numberType), l.right.inferExpression(in))
+	return in.typeFor(numberType)
+}
+
+func (t *term) inferExpression(in *inferrer) inferredType {
+	in.unify(t.left.position(), in.typeFor(numberType), t.left.inferExpression(in))
+	in.unify(t.right.position(), in.typeFor(numberType), t.right.inferExpression(in))
+	return in.typeFor(numberType)
+}
+
+func (l *logicalNotExpression) inferExpression(in *inferrer) inferredType {
+	in.unify(l.booleanExpression.position(), in.typeFor(booleanType), l.booleanExpression.inferExpression(in))
+	return in.typeFor(booleanType)
+}
+
+func (i *identifier) inferExpression(in *inferrer) inferredType {
+	if symbol := in.scope.resolve(i.value); symbol != nil {
+		in.record(i.pos, symbol.value.(inferredType))
+		return symbol.value.(inferredType)
+	}
+	return in.fresh()
+}
+
+func (n *numberLiteral) inferExpression(in *inferrer) inferredType {
+	return in.typeFor(numberType)
+}
+
+func (s *stringLiteral) inferExpression(in *inferrer) inferredType {
+	return in.typeFor(stringType)
+}
+
+func (i *interpolationExpression) inferExpression(in *inferrer) inferredType {
+	for _, e := range i.expressions {
+		e.inferExpression(in)
+	}
+	return in.typeFor(stringType)
+}
+
+func (b *booleanLiteral) inferExpression(in *inferrer) inferredType {
+	return in.typeFor(booleanType)
+}
+
+func (l *listLiteral) inferExpression(in *inferrer) inferredType {
+	element := in.fresh()
+	for _, e := range l.elements {
+		in.unify(e.position(), element, e.inferExpression(in))
+	}
+	return &typeOperator{"list", []inferredType{element}}
+}
+
+func (m *mapLiteral) inferExpression(in *inferrer) inferredType {
+	key, value := in.fresh(), in.fresh()
+	for i, k := range m.keys {
+		in.unify(k.position(), key, k.inferExpression(in))
+		in.unify(m.values[i].position(), value, m.values[i].inferExpression(in))
+	}
+	return &typeOperator{"map", []inferredType{key, value}}
+}
+
+func (s *structStatement) inferStatement(in *inferrer) {
+	fields := map[string]inferredType{}
+	in.structs[s.name] = fields
+	for i, field := range s.fields {
+		fields[field] = in.fresh()
+		if s.fieldTypes[i] != "" {
+			in.unify(s.pos, in.annotation(s.fieldTypes[i]), fields[field])
+		}
+	}
+}
+
+func (s *structLiteral) inferExpression(in *inferrer) inferredType {
+	for i, field := range s.fields {
+		in.unify(s.values[i].position(), in.structs[s.name][field], s.values[i].inferExpression(in))
+	}
+	return &typeOperator{s.name, nil}
+}
+
+// fieldType infers the type of field in a value of type t. When t is not
+// yet known it is taken to be the only struct declaring such a field.
+func (in *inferrer) fieldType(pos pos, t inferredType, field string) inferredType {
+	names := map[*typeVariable]string{}
+	switch t := prune(t).(type) {
+	case *typeVariable:
+		var owner string
+		for name, fields := range in.structs {
+			if _, ok := fields[field]; ok {
+				if owner != "" {
+					return in.fresh()
+				}
+				owner = name
+			}
+		}
+		if owner == "" {
+			in.errorf(pos, "unrecognized field '%s'", field)
+			return in.fresh()
+		}
+		in.unify(pos, &typeOperator{owner, nil}, t)
+		return in.structs[owner][field]
+	case *typeOperator:
+		fields, ok := in.structs[t.name]
+		if !ok {
+			in.errorf(pos, "cannot access field '%s' of %s", field, typeString(t, names))
+			return in.fresh()
+		}
+		if f, ok := fields[field]; ok {
+			return f
+		}
+		in.errorf(pos, "unrecognized field '%s' in %s", field, t.name)
+	}
+	return in.fresh()
+}
+
+func (f *fieldExpression) inferExpression(in *inferrer) inferredType {
+	return in.fieldType(f.pos, f.object.inferExpression(in), f.field)
+}
+
+func (f *fieldAssignmentStatement) inferStatement(in *inferrer) {
+	t := in.fieldType(f.pos, f.object.inferExpression(in), f.field)
+	in.unify(f.expression.position(), t, f.expression.inferExpression(in))
+}
+
+func (cs *classStatement) inferStatement(in *inferrer) {
+	fields := map[string]inferredType{}
+	if parent, ok := in.classes[cs.parent]; ok {
+		for name, t := range in.structs[parent.name] {
+			fields[name] = t
+		}
+	}
+	for _, field := range cs.fields {
+		t := field.expression.inferExpression(in)
+		if field.typeName != "" {
+			in.unify(field.expression.position(), in.annotation(field.typeName), t)
+		}
+		if inherited, ok := fields[field.id]; ok {
+			in.unify(field.expression.position(), inherited, t)
+		}
+		fields[field.id] = t
+	}
+	in.structs[cs.name] = fields
+	in.classes[cs.name] = cs
+	for _, m := range cs.methods {
+		in.order = append(in.order, m)
+		in.methods[m] = cs
+		in.methodType(cs, m)
+	}
+}
+
+func (s *superExpression) inferExpression(in *inferrer) inferredType {
+	return &typeOperator{s.class, nil}
+}
+
+func (e *enumStatement) inferStatement(in *inferrer) {
+	in.enums[e.name] = e
+	for _, v := range e.variants {
+		var fields []inferredType
+		for i := range v.fields {
+			t := in.fresh()
+			if v.fieldTypes[i] != "" {
+				in.unify(v.pos, in.annotation(v.fieldTypes[i]), t)
+			}
+			fields = append(fields, t)
+		}
+		in.variants[v] = fields
+	}
+}
+
+// variantFields infers the payload types of variant of enum, which are
+// unknown until the enum declaration has been inferred.
+func (in *inferrer) variantFields(enum string, variant string, n int) []inferredType {
+	if e, ok := in.enums[enum]; ok {
+		return in.variants[e.variant(variant)]
+	}
+	var fields []inferredType
+	for i := 0; i < n; i++ {
+		fields = append(fields, in.fresh())
+	}
+	return fields
+}
+
+func (v *variantExpression) inferExpression(in *inferrer) inferredType {
+	fields := in.variantFields(v.enum, v.variant, len(v.arguments))
+	for i, arg := range v.arguments {
+		in.unify(arg.position(), fields[i], arg.inferExpression(in))
+	}
+	return &typeOperator{v.enum, nil}
+}
+
+func (m *matchExpression) inferStatement(in *inferrer) {
+	m.inferExpression(in)
+}
+
+func (m *matchExpression) inferExpression(in *inferrer) inferredType {
+	result := in.fresh()
+	t := m.subject.inferExpression(in)
+	for _, arm := range m.arms {
+		outer := in.scope
+		in.scope = newScope(outer)
+		arm.pattern.inferPattern(in, t)
+		if arm.guard != nil {
+			in.unify(arm.guard.position(), in.typeFor(booleanType), arm.guard.inferExpression(in))
+		}
+		in.unify(arm.body.position(), result, arm.body.inferExpression(in))
+		in.scope = outer
+	}
+	return result
+}
+
+func (l *literalPattern) inferPattern(in *inferrer, t inferredType) {
+	in.unify(l.pos, t, l.literal.inferExpression(in))
+}
+
+func (b *bindingPattern) inferPattern(in *inferrer, t inferredType) {
+	in.scope.declare(b.name, t)
+}
+
+func (w *wildcardPattern) inferPattern(in *inferrer, t inferredType) {
+}
+
+func (v *variantPattern) inferPattern(in *inferrer, t inferredType) {
+	in.unify(v.pos, t, &typeOperator{v.enum, nil})
+	fields := in.variantFields(v.enum, v.variant, len(v.patterns))
+	for i, pattern := range v.patterns {
+		pattern.inferPattern(in, fields[i])
+	}
+}
+
+// inferModule infers the types in module m, once, returning the scope of
+// its globals. Its functions are left out of the reported signatures.
+func (in *inferrer) inferModule(m *module) *scope {
+	if root, ok := in.modules[m]; ok {
+		return root
+	}
+	root := in.globalScope()
+	in.modules[m] = root
+	outer, functions, order, globals, prefix := in.root, in.functions, in.order, in.globals, in.prefix
+	in.root, in.scope, in.functions, in.prefix = root, root, map[string]*functionStatement{}, m.path+": "
+	collectFunctions(m.block, in.functions)
+	m.block.inferStatement(in)
+	in.root, in.scope, in.functions, in.order, in.globals, in.prefix = outer, outer, functions, order, globals, prefix
+	return root
+}
+
+func (t *testStatement) inferStatement(in *inferrer) {
+	in.inferBlock(t.block, newScope(in.scope))
+}
+
+func (i *importStatement) inferStatement(in *inferrer) {
+	root := in.inferModule(i.module)
+	for _, e := range i.module.exports {
+		switch s := e.statement.(type) {
+		case *declarationStatement:
+			in.scope.declare(e.name, root.resolve(e.name).value)
+		case *functionStatement:
+			in.functions[e.name] = s
+		}
+	}
+}
+
+func (e *exportStatement) inferStatement(in *inferrer) {
+	e.statement.inferStatement(in)
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"strconv"
 )
 
@@ -15,8 +15,28 @@ type (
 	statement      struct {
 		typeValue  statementType
 		expression *expression
+		label      string
 	}
 	statementType int
+	structValue   struct {
+		definition *structStatement
+		fields     map[string]*expression
+	}
+	object struct {
+		class  *classStatement
+		fields map[string]*expression
+	}
+	// functionValue is a function along with the scope it was declared in,
+	// in which its body runs.
+	functionValue struct {
+		definition *functionStatement
+		scope      *scope
+	}
+	variantValue struct {
+		enum    *enumStatement
+		variant *enumVariant
+		values  []*expression
+	}
 )
 
 const (
@@ -24,102 +44,257 @@ const (
 	blockType
 	breakType
 	callType
+	classDeclarationType
 	continueType
 	declarationType
+	enumDeclarationType
+	forType
 	functionType
 	ifType
+	importType
+	matchType
 	printType
 	returnType
+	structDeclarationType
+	testType
 	whileType
 
 	booleanType expressionType = 1 << iota
+	enumType
+	listType
+	mapType
 	numberType
+	objectType
+	rangeType
 	stringType
+	structType
 )
 
 var (
-	functions = map[string]*functionStatement{}
-	rootScope = newScope(nil)
-	types     = map[expressionType]string{
+	// declarationScopes maps each struct, class and enum to the scope it
+	// was declared in, in which the types its fields are annotated with
+	// are resolved, and the field initializers and methods of classes run.
+	// Structs, classes and enums are declared in scopes like functions, so
+	// that each module has its own.
+	declarationScopes = map[statementVisitor]*scope{}
+	types             = map[expressionType]string{
 		numberType:  "number",
 		stringType:  "string",
 		booleanType: "boolean",
+		enumType:    "enum",
+		listType:    "list",
+		mapType:     "map",
+		objectType:  "object",
+		rangeType:   "range",
+		structType:  "struct",
 	}
 )
 
+// observer watches the program run. statement is called before each
+// statement of a block runs, in the scope it runs in, and result after it,
+// with the value it produced if any; branch is called each time the
+// condition of an if or while statement is evaluated, with whether its
+// block is to run; enter and exit are called around the body of each
+// function called, enter with the scope the body runs in and exit with the
+// value it returns.
+type observer interface {
+	statement(s statementVisitor, scope *scope)
+	result(s statementVisitor, value *expression)
+	branch(s statementVisitor, taken bool)
+	enter(f *functionStatement, scope *scope)
+	exit(f *functionStatement, result *expression)
+}
+
+var observers []observer
+
+// calls holds the functions being called, innermost last, so that those
+// an error recovered from leaves without returning can be exited.
+var calls []*functionStatement
+
+// unwind exits the functions called since there were depth calls.
+func unwind(depth int) {
+	for len(calls) > depth {
+		f := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		for _, o := range observers {
+			o.exit(f, nil)
+		}
+	}
+}
+
+// runtimeError is an error that stops the program. It is panicked with,
+// so that tools running the program can recover from it.
+type runtimeError struct {
+	message string
+	// assertion is set if a failed assertion raised the error, and
+	// expected and actual to the values compared by a failed assert_eq.
+	assertion        bool
+	expected, actual *expression
+}
+
+func (e *runtimeError) Error() string {
+	return e.message
+}
+
+func fail(format string, args ...interface{}) {
+	panic(&runtimeError{message: fmt.Sprintf(format, args...)})
+}
+
 func interpret(file string) {
-	parse(lex(file)).visitStatement(rootScope)
+	m := load(file)
+	if !*coverFlag && *coverProfileFlag == "" {
+		optimizeModules()
+	}
+	if *traceFlag {
+		observers = append(observers, newTracer(m))
+	}
+	if *profileFlag || *pprofFlag != "" {
+		p := newProfiler(m)
+		observers = append(observers, p)
+		defer p.report(*profileFlag, *pprofFlag)
+	}
+	if *coverFlag || *coverProfileFlag != "" {
+		c := newCoverage()
+		observers = append(observers, c)
+		defer c.report(*coverProfileFlag)
+	}
+	m.evaluate()
 }
 
 func (a *declarationStatement) visitStatement(scope *scope) *statement {
-	scope.declare(a.id, a.expression.visitExpression(scope))
-	return &statement{declarationType, nil}
+	e := a.expression.visitExpression(scope)
+	if a.typeName != "" {
+		annotationCheck(scope, a.typeName, e)
+	}
+	scope.declare(a.id, e)
+	return &statement{declarationType, e, ""}
 }
 
 func (a *assignmentStatement) visitStatement(scope *scope) *statement {
 	if scope.resolve(a.id) != nil {
-		scope.assign(a.id, a.expression.visitExpression(scope))
-		return &statement{assignmentType, nil}
+		e := a.expression.visitExpression(scope)
+		scope.assign(a.id, e)
+		return &statement{assignmentType, e, ""}
 	}
-	fmt.Fprintf(os.Stderr, "unrecognized var: '%s'\n", a.id)
-	os.Exit(1)
+	fail("unrecognized var: '%s'", a.id)
 	return nil
 }
 
 func (i *ifStatement) visitStatement(scope *scope) *statement {
 	b := i.booleanExpression.visitExpression(scope)
 	typeCheck(booleanType, b)
+	for _, o := range observers {
+		o.branch(i, b.value.(bool))
+	}
 	if b.value.(bool) {
 		return i.block.visitStatement(newScope(scope))
 	}
-	return &statement{ifType, nil}
+	return &statement{ifType, nil, ""}
 }
 
 func (i *whileStatement) visitStatement(scope *scope) *statement {
 	for {
 		b := i.booleanExpression.visitExpression(scope)
 		typeCheck(booleanType, b)
+		for _, o := range observers {
+			o.branch(i, b.value.(bool))
+		}
 		if !b.value.(bool) {
 			break
 		}
 		v := i.block.visitStatement(newScope(scope))
-		switch v.typeValue {
-		case breakType, returnType:
-			return v
+		if exit, result := exitLoop(v, i.label, whileType); exit {
+			return result
+		}
+	}
+	return &statement{whileType, nil, ""}
+}
+
+func (f *forStatement) visitStatement(scope *scope) *statement {
+	collection := f.collection.visitExpression(scope)
+	it := iterate(collection)
+	for index := 0; ; index++ {
+		value, ok := it.next()
+		if !ok {
+			break
+		}
+		newScope := newScope(scope)
+		if f.key != "" {
+			key := &expression{numberType, index}
+			if collection.typeValue == mapType {
+				key, value = value, collection.value.(*mapValue).get(value)
+			}
+			newScope.declare(f.key, key)
+		}
+		newScope.declare(f.value, value)
+		v := f.block.visitStatement(newScope)
+		if exit, result := exitLoop(v, f.label, forType); exit {
+			return result
 		}
 	}
-	return &statement{whileType, nil}
+	return &statement{forType, nil, ""}
+}
+
+// exitLoop reports whether a loop with the given label must stop after its
+// block produced v, and what the loop should then produce. Unlabeled break
+// and continue apply to the innermost loop; labeled ones propagate outwards
+// until they reach the loop carrying their label.
+func exitLoop(v *statement, label string, typeValue statementType) (bool, *statement) {
+	switch v.typeValue {
+	case breakType:
+		if v.label == "" || v.label == label {
+			return true, &statement{typeValue, nil, ""}
+		}
+		return true, v
+	case continueType:
+		if v.label == "" || v.label == label {
+			return false, nil
+		}
+		return true, v
+	case returnType:
+		return true, v
+	}
+	return false, nil
 }
 
 func (i *breakStatement) visitStatement(scope *scope) *statement {
-	return &statement{breakType, nil}
+	return &statement{breakType, nil, i.label}
 }
 
 func (i *continueStatement) visitStatement(scope *scope) *statement {
-	return &statement{continueType, nil}
+	return &statement{continueType, nil, i.label}
 }
 
 func (f *functionStatement) visitStatement(scope *scope) *statement {
-	functions[f.name] = f
-	return &statement{functionType, nil}
+	scope.declare(f.name, &functionValue{f, scope})
+	return &statement{functionType, nil, ""}
 }
 
 func (r *returnStatement) visitStatement(scope *scope) *statement {
-	return &statement{returnType, r.expression.visitExpression(scope)}
+	if r.expression == nil {
+		return &statement{returnType, nil, ""}
+	}
+	return &statement{returnType, r.expression.visitExpression(scope), ""}
 }
 
 func (b *block) visitStatement(scope *scope) *statement {
 	for _, s := range b.statements {
+		for _, o := range observers {
+			o.statement(s, scope)
+		}
 		v := s.visitStatement(scope)
 		if v == nil {
 			continue
 		}
+		for _, o := range observers {
+			o.result(s, v.expression)
+		}
 		switch v.typeValue {
-		case returnType:
+		case breakType, continueType, returnType:
 			return v
 		}
 	}
-	return &statement{blockType, nil}
+	return &statement{blockType, nil, ""}
 }
 
 func (b *booleanExpression) visitExpression(scope *scope) *expression {
@@ -127,50 +302,54 @@ func (b *booleanExpression) visitExpression(scope *scope) *expression {
 	if b.right == nil {
 		return left
 	}
-	right := b.right.visitExpression(scope)
-	expectSameType(left, right)
-
+	// The right operand of and and or is only evaluated when the left one
+	// does not decide the result.
 	switch b.operator {
-	case "and":
-		typeCheck(booleanType, left, right)
-		return &expression{booleanType, left.value.(bool) && right.value.(bool)}
-	case "or":
-		typeCheck(booleanType, left, right)
-		return &expression{booleanType, left.value.(bool) || right.value.(bool)}
+	case "and", "or":
+		typeCheck(booleanType, left)
+		if left.value.(bool) == (b.operator == "or") {
+			return left
+		}
+		right := b.right.visitExpression(scope)
+		typeCheck(booleanType, right)
+		return right
 	}
+	right := b.right.visitExpression(scope)
+	expectSameType(left, right)
 
 	switch left.typeValue {
 	case numberType:
-		return evaluateNumberComparison(left.value.(int), b.operator, right.value.(int))
+		return evaluateNumberComparison(left.value, b.operator, right.value)
 	case stringType:
 		return evaluateStringComparison(left.value.(string), b.operator, right.value.(string))
 	case booleanType:
 		return evaluateBooleanComparison(left.value.(bool), b.operator, right.value.(bool))
+	case enumType, listType, mapType, objectType, rangeType, structType:
+		return evaluateEquality(left, b.operator, right)
 	default:
-		fmt.Fprintln(os.Stderr, "unrecognized type")
-		os.Exit(1)
+		fail("unrecognized type")
 	}
 	return &expression{booleanType, false}
 }
 
-func evaluateNumberComparison(left int, operator string, right int) *expression {
+func evaluateNumberComparison(left interface{}, operator string, right interface{}) *expression {
 	var b bool
+	c := compareNumbers(left, right)
 	switch operator {
 	case "==":
-		b = left == right
+		b = c == 0
 	case "!=":
-		b = left != right
+		b = c != 0
 	case ">=":
-		b = left >= right
+		b = c >= 0
 	case ">":
-		b = left > right
+		b = c > 0
 	case "<":
-		b = left < right
+		b = c < 0
 	case "<=":
-		b = left <= right
+		b = c <= 0
 	default:
-		fmt.Fprintln(os.Stderr, "unrecognized operator")
-		os.Exit(1)
+		fail("unrecognized operator")
 	}
 	return &expression{booleanType, b}
 }
@@ -191,8 +370,7 @@ func evaluateStringComparison(left string, operator string, right string) *expre
 	case "<=":
 		b = left <= right
 	default:
-		fmt.Fprintln(os.Stderr, "unrecognized operator")
-		os.Exit(1)
+		fail("unrecognized operator")
 	}
 	return &expression{booleanType, b}
 }
@@ -205,25 +383,100 @@ func evaluateBooleanComparison(left bool, operator string, right bool) *expressi
 	case "!=":
 		b = left != right
 	default:
-		fmt.Fprintln(os.Stderr, "unrecognized operator")
-		os.Exit(1)
+		fail("unrecognized operator")
+	}
+	return &expression{booleanType, b}
+}
+
+func evaluateEquality(left *expression, operator string, right *expression) *expression {
+	var b bool
+	switch operator {
+	case "==":
+		b = equal(left, right)
+	case "!=":
+		b = !equal(left, right)
+	default:
+		fail("unrecognized operator")
 	}
 	return &expression{booleanType, b}
 }
 
+func equal(left *expression, right *expression) bool {
+	return equalValues(left, right, map[[2]*structValue]bool{})
+}
+
+// equalValues compares left and right, taking the pairs of structs in
+// compared, whose comparison encloses this one, to be equal, so that
+// structs containing themselves are compared.
+func equalValues(left *expression, right *expression, compared map[[2]*structValue]bool) bool {
+	if left.typeValue != right.typeValue {
+		return false
+	}
+	switch left.typeValue {
+	case listType:
+		l, r := left.value.([]*expression), right.value.([]*expression)
+		if len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !equalValues(l[i], r[i], compared) {
+				return false
+			}
+		}
+		return true
+	case mapType:
+		l, r := left.value.(*mapValue), right.value.(*mapValue)
+		if len(l.keys) != len(r.keys) {
+			return false
+		}
+		for _, key := range l.keys {
+			if v := r.get(key); v == nil || !equalValues(l.get(key), v, compared) {
+				return false
+			}
+		}
+		return true
+	case numberType:
+		return compareNumbers(left.value, right.value) == 0
+	case rangeType:
+		return *left.value.(*rangeValue) == *right.value.(*rangeValue)
+	case enumType:
+		l, r := left.value.(*variantValue), right.value.(*variantValue)
+		if l.variant != r.variant {
+			return false
+		}
+		for i := range l.values {
+			if !equalValues(l.values[i], r.values[i], compared) {
+				return false
+			}
+		}
+		return true
+	case structType:
+		l, r := left.value.(*structValue), right.value.(*structValue)
+		if l == r || compared[[2]*structValue{l, r}] {
+			return true
+		}
+		if l.definition != r.definition {
+			return false
+		}
+		compared[[2]*structValue{l, r}] = true
+		for _, field := range l.definition.fields {
+			if !equalValues(l.fields[field], r.fields[field], compared) {
+				return false
+			}
+		}
+		return true
+	}
+	return left.value == right.value
+}
+
 func (e *logicalOperand) visitExpression(scope *scope) *expression {
 	left := e.left.visitExpression(scope)
 	if e.right != nil {
 		right := e.right.visitExpression(scope)
 		typeCheck(numberType, left, right)
-		switch e.operator {
-		case "+":
-			return &expression{numberType, left.value.(int) + right.value.(int)}
-		case "-":
-			return &expression{numberType, left.value.(int) - right.value.(int)}
-		}
+		return evaluateArithmetic(left, e.operator, right)
 	}
-	return e.left.visitExpression(scope)
+	return left
 }
 
 func (t *term) visitExpression(scope *scope) *expression {
@@ -231,14 +484,17 @@ func (t *term) visitExpression(scope *scope) *expression {
 	if t.right != nil {
 		right := t.right.visitExpression(scope)
 		typeCheck(numberType, left, right)
-		switch t.operator {
-		case "*":
-			return &expression{numberType, left.value.(int) * right.value.(int)}
-		case "/":
-			return &expression{numberType, left.value.(int) / right.value.(int)}
-		}
+		return evaluateArithmetic(left, t.operator, right)
 	}
-	return t.left.visitExpression(scope)
+	return left
+}
+
+func evaluateArithmetic(left *expression, operator string, right *expression) *expression {
+	e, err := arithmetic(left.value, operator, right.value)
+	if err != nil {
+		fail("%s", err)
+	}
+	return e
 }
 
 func (e *logicalNotExpression) visitExpression(scope *scope) *expression {
@@ -248,29 +504,163 @@ func (e *logicalNotExpression) visitExpression(scope *scope) *expression {
 }
 
 func (c *callExpression) visitExpression(scope *scope) *expression {
-	f, ok := functions[c.name]
-	if !ok {
-		expr, err := visitBuiltin(c, scope)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+	if c.receiver != nil {
+		return c.visitMethod(scope)
+	}
+	if symbol := scope.resolve(c.name); symbol != nil {
+		if f, ok := symbol.value.(*functionValue); ok {
+			return call(f.definition, c.visitArguments(scope), newScope(f.scope))
 		}
-		return expr
 	}
-	newScope := newScope(scope)
+	if class, ok := declared(scope, c.name).(*classStatement); ok {
+		return construct(class, c.visitArguments(scope))
+	}
+	if assertions[c.name] {
+		return c.visitAssertion(scope)
+	}
+	expr, err := visitBuiltin(c, scope)
+	if err != nil {
+		fail("%s", err)
+	}
+	return expr
+}
+
+func (c *callExpression) visitMethod(scope *scope) *expression {
+	var self *expression
+	var class *classStatement
+	if s, ok := c.receiver.(*superExpression); ok {
+		self = scope.resolve("self").value.(*expression)
+		if current, ok := declared(scope, s.class).(*classStatement); ok {
+			class = parentOf(current)
+		}
+		if class == nil {
+			fail("class %s has no parent", s.class)
+		}
+	} else {
+		self = c.receiver.visitExpression(scope)
+		if self.typeValue != objectType {
+			fail("cannot call method '%s' on %s", c.name, types[self.typeValue])
+		}
+		class = self.value.(*object).class
+	}
+	m, owner := methodIn(parentOf, class, c.name)
+	if m == nil {
+		fail("unrecognized method '%s' in class %s", c.name, class.name)
+	}
+	return invoke(self, owner, m, c.visitArguments(scope))
+}
+
+func (c *callExpression) visitArguments(scope *scope) []*expression {
+	var args []*expression
+	for _, arg := range c.arguments {
+		args = append(args, arg.visitExpression(scope))
+	}
+	return args
+}
+
+// call runs f with its parameters bound to args in scope.
+func call(f *functionStatement, args []*expression, scope *scope) *expression {
+	if len(args) != len(f.parameters) {
+		fail("fn '%s' expects %d arguments, got %d", f.name, len(f.parameters), len(args))
+	}
 	for i, p := range f.parameters {
-		newScope.declare(p, c.arguments[i].visitExpression(scope))
+		if f.parameterTypes[i] != "" {
+			annotationCheck(scope, f.parameterTypes[i], args[i])
+		}
+		scope.declare(p, args[i])
 	}
-	v := f.block.visitStatement(newScope)
-	switch v.typeValue {
-	case returnType:
-		return v.expression
+	for _, o := range observers {
+		o.enter(f, scope)
 	}
-	return nil
+	calls = append(calls, f)
+	var result *expression
+	if v := f.block.visitStatement(scope); v.typeValue == returnType {
+		if f.returnType != "" && v.expression != nil {
+			annotationCheck(scope, f.returnType, v.expression)
+		}
+		result = v.expression
+	}
+	calls = calls[:len(calls)-1]
+	for _, o := range observers {
+		o.exit(f, result)
+	}
+	return result
+}
+
+// invoke calls method, defined by class, on self.
+func invoke(self *expression, class *classStatement, method *functionStatement, args []*expression) *expression {
+	methodScope := newScope(declarationScopes[class])
+	methodScope.declare("self", self)
+	return call(method, args, methodScope)
+}
+
+func construct(class *classStatement, args []*expression) *expression {
+	self := &expression{objectType, &object{class, map[string]*expression{}}}
+	initialize(class, self.value.(*object))
+	if init, owner := methodIn(parentOf, class, "init"); init != nil {
+		invoke(self, owner, init, args)
+	} else if len(args) != 0 {
+		fail("class %s expects 0 arguments, got %d", class.name, len(args))
+	}
+	return self
+}
+
+func initialize(class *classStatement, o *object) {
+	if parent := parentOf(class); parent != nil {
+		initialize(parent, o)
+	}
+	for _, field := range class.fields {
+		e := field.expression.visitExpression(declarationScopes[class])
+		if field.typeName != "" {
+			annotationCheck(declarationScopes[class], field.typeName, e)
+		}
+		o.fields[field.id] = e
+	}
+}
+
+func findMethod(class *classStatement, name string) *functionStatement {
+	m, _ := methodIn(parentOf, class, name)
+	return m
+}
+
+// methodIn looks up the method name in class and its ancestors, found by
+// parent, returning it along with the class that defines it.
+func methodIn(parent func(*classStatement) *classStatement, class *classStatement, name string) (*functionStatement, *classStatement) {
+	for ; class != nil; class = parent(class) {
+		for _, m := range class.methods {
+			if m.name == name {
+				return m, class
+			}
+		}
+	}
+	return nil, nil
+}
+
+// classFields lists the fields of class, starting with those it inherits.
+func classFields(class *classStatement) []string {
+	var fields []string
+	if parent := parentOf(class); parent != nil {
+		fields = classFields(parent)
+	}
+	for _, field := range class.fields {
+		if indexOf(fields, field.id) < 0 {
+			fields = append(fields, field.id)
+		}
+	}
+	return fields
+}
+
+func instanceOf(classes map[string]*classStatement, class *classStatement, name string) bool {
+	for ; class != nil; class = classes[class.parent] {
+		if class.name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *callExpression) visitStatement(scope *scope) *statement {
-	return &statement{callType, c.visitExpression(scope)}
+	return &statement{callType, c.visitExpression(scope), ""}
 }
 
 func (i *identifier) visitExpression(scope *scope) *expression {
@@ -278,27 +668,113 @@ func (i *identifier) visitExpression(scope *scope) *expression {
 }
 
 func (nl *numberLiteral) visitExpression(scope *scope) *expression {
-	n, err := strconv.Atoi(nl.value)
+	if n, err := strconv.Atoi(nl.value); err == nil {
+		return &expression{numberType, n}
+	}
+	f, err := strconv.ParseFloat(nl.value, 64)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "expected number")
-		os.Exit(1)
+		fail("expected number")
 	}
-	return &expression{numberType, n}
+	return &expression{numberType, f}
 }
 
 func (s *stringLiteral) visitExpression(scope *scope) *expression {
 	return &expression{stringType, s.value}
 }
 
+func (i *interpolationExpression) visitExpression(scope *scope) *expression {
+	var buf bytes.Buffer
+	for j, e := range i.expressions {
+		buf.WriteString(i.segments[j])
+		buf.WriteString(display(e.visitExpression(scope)))
+	}
+	buf.WriteString(i.segments[len(i.segments)-1])
+	return &expression{stringType, buf.String()}
+}
+
 func (b *booleanLiteral) visitExpression(scope *scope) *expression {
 	return &expression{booleanType, b.value}
 }
 
+func (l *listLiteral) visitExpression(scope *scope) *expression {
+	var elements []*expression
+	for _, e := range l.elements {
+		elements = append(elements, e.visitExpression(scope))
+	}
+	return &expression{listType, elements}
+}
+
+func (m *mapLiteral) visitExpression(scope *scope) *expression {
+	v := newMapValue()
+	for i, k := range m.keys {
+		v.set(k.visitExpression(scope), m.values[i].visitExpression(scope))
+	}
+	return &expression{mapType, v}
+}
+
+func typeNamed(name string) expressionType {
+	for t, n := range types {
+		if n == name {
+			return t
+		}
+	}
+	return 0
+}
+
+// annotationCheck checks that e has the type typeName names in scope.
+func annotationCheck(scope *scope, typeName string, e *expression) {
+	switch t := declared(scope, typeName).(type) {
+	case *classStatement:
+		typeCheck(objectType, e)
+		if o := e.value.(*object); !isA(o.class, t) {
+			fail("type mismatch: %s != %s", o.class.name, t.name)
+		}
+	case *enumStatement:
+		typeCheck(enumType, e)
+		if enum := e.value.(*variantValue).enum; enum != t {
+			fail("type mismatch: %s != %s", enum.name, t.name)
+		}
+	case *structStatement:
+		typeCheck(structType, e)
+		if definition := e.value.(*structValue).definition; definition != t {
+			fail("type mismatch: %s != %s", definition.name, t.name)
+		}
+	default:
+		typeCheck(typeNamed(typeName), e)
+	}
+}
+
+// declared returns what name is declared as in scope, or nil.
+func declared(scope *scope, name string) interface{} {
+	if symbol := scope.resolve(name); symbol != nil {
+		return symbol.value
+	}
+	return nil
+}
+
+// parentOf returns the class class inherits from, or nil.
+func parentOf(class *classStatement) *classStatement {
+	if class.parent == "" {
+		return nil
+	}
+	parent, _ := declared(declarationScopes[class], class.parent).(*classStatement)
+	return parent
+}
+
+// isA reports whether class is ancestor or inherits from it.
+func isA(class *classStatement, ancestor *classStatement) bool {
+	for ; class != nil; class = parentOf(class) {
+		if class == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
 func typeCheck(b expressionType, args ...*expression) {
 	for _, arg := range args {
 		if arg.typeValue != b {
-			fmt.Fprintf(os.Stderr, "type mismatch: %s != %s\n", types[arg.typeValue], types[b])
-			os.Exit(1)
+			fail("type mismatch: %s != %s", types[arg.typeValue], types[b])
 		}
 	}
 }
@@ -321,3 +797,180 @@ func visitBuiltin(c *callExpression, scope *scope) (*expression, error) {
 	}
 	return builtin(c.name, args)
 }
+
+func (s *structStatement) visitStatement(scope *scope) *statement {
+	scope.declare(s.name, s)
+	declarationScopes[s] = scope
+	return &statement{structDeclarationType, nil, ""}
+}
+
+func (s *structLiteral) visitExpression(scope *scope) *expression {
+	definition, ok := declared(scope, s.name).(*structStatement)
+	if !ok {
+		fail("unrecognized struct: '%s'", s.name)
+	}
+	v := &structValue{definition, map[string]*expression{}}
+	for i, field := range s.fields {
+		e := s.values[i].visitExpression(scope)
+		if typeName := definition.fieldTypes[indexOf(definition.fields, field)]; typeName != "" {
+			annotationCheck(declarationScopes[definition], typeName, e)
+		}
+		v.fields[field] = e
+	}
+	return &expression{structType, v}
+}
+
+func (f *fieldExpression) visitExpression(scope *scope) *expression {
+	fields, _, _ := fieldsOf(f.object.visitExpression(scope), f.field)
+	return fields[f.field]
+}
+
+func (f *fieldAssignmentStatement) visitStatement(scope *scope) *statement {
+	fields, typeName, declaration := fieldsOf(f.object.visitExpression(scope), f.field)
+	e := f.expression.visitExpression(scope)
+	if typeName != "" {
+		annotationCheck(declarationScopes[declaration], typeName, e)
+	}
+	fields[f.field] = e
+	return &statement{assignmentType, e, ""}
+}
+
+// fieldsOf returns the fields of the struct or object e, which must have a
+// field named field, along with the type the field is annotated with and
+// the struct or class declaring it.
+func fieldsOf(e *expression, field string) (map[string]*expression, string, statementVisitor) {
+	switch e.typeValue {
+	case structType:
+		v := e.value.(*structValue)
+		if i := indexOf(v.definition.fields, field); i >= 0 {
+			return v.fields, v.definition.fieldTypes[i], v.definition
+		}
+		fail("unrecognized field '%s' in struct %s", field, v.definition.name)
+	case objectType:
+		o := e.value.(*object)
+		for class := o.class; class != nil; class = parentOf(class) {
+			for _, f := range class.fields {
+				if f.id == field {
+					return o.fields, f.typeName, class
+				}
+			}
+		}
+		fail("unrecognized field '%s' in class %s", field, o.class.name)
+	}
+	fail("cannot access field '%s' of %s", field, types[e.typeValue])
+	return nil, "", nil
+}
+
+func (c *classStatement) visitStatement(scope *scope) *statement {
+	scope.declare(c.name, c)
+	declarationScopes[c] = scope
+	return &statement{classDeclarationType, nil, ""}
+}
+
+func (s *superExpression) visitExpression(scope *scope) *expression {
+	return scope.resolve("self").value.(*expression)
+}
+
+func (e *enumStatement) visitStatement(scope *scope) *statement {
+	scope.declare(e.name, e)
+	declarationScopes[e] = scope
+	return &statement{enumDeclarationType, nil, ""}
+}
+
+func (e *enumStatement) variant(name string) *enumVariant {
+	for _, v := range e.variants {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func (v *variantExpression) visitExpression(scope *scope) *expression {
+	enum, ok := declared(scope, v.enum).(*enumStatement)
+	if !ok {
+		fail("unrecognized enum: '%s'", v.enum)
+	}
+	variant := enum.variant(v.variant)
+	value := &variantValue{enum, variant, nil}
+	for i, arg := range v.arguments {
+		e := arg.visitExpression(scope)
+		if variant.fieldTypes[i] != "" {
+			annotationCheck(declarationScopes[enum], variant.fieldTypes[i], e)
+		}
+		value.values = append(value.values, e)
+	}
+	return &expression{enumType, value}
+}
+
+func (m *matchExpression) visitStatement(scope *scope) *statement {
+	return &statement{matchType, m.visitExpression(scope), ""}
+}
+
+func (m *matchExpression) visitExpression(scope *scope) *expression {
+	e := m.subject.visitExpression(scope)
+	for _, arm := range m.arms {
+		armScope := newScope(scope)
+		if !arm.pattern.matchPattern(e, armScope) {
+			continue
+		}
+		if arm.guard != nil {
+			b := arm.guard.visitExpression(armScope)
+			typeCheck(booleanType, b)
+			if !b.value.(bool) {
+				continue
+			}
+		}
+		return arm.body.visitExpression(armScope)
+	}
+	fail("no match for %s", e)
+	return nil
+}
+
+func (l *literalPattern) matchPattern(e *expression, scope *scope) bool {
+	return equal(l.literal.visitExpression(scope), e)
+}
+
+func (b *bindingPattern) matchPattern(e *expression, scope *scope) bool {
+	scope.declare(b.name, e)
+	return true
+}
+
+func (w *wildcardPattern) matchPattern(e *expression, scope *scope) bool {
+	return true
+}
+
+func (v *variantPattern) matchPattern(e *expression, scope *scope) bool {
+	if e.typeValue != enumType {
+		return false
+	}
+	value := e.value.(*variantValue)
+	if value.enum != declared(scope, v.enum) || value.variant.name != v.variant {
+		return false
+	}
+	for i, pattern := range v.patterns {
+		if !pattern.matchPattern(value.values[i], scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *importStatement) visitStatement(scope *scope) *statement {
+	i.module.evaluate()
+	for _, e := range i.module.exports {
+		if symbol, ok := i.module.scope.symbols[e.name]; ok {
+			scope.symbols[e.name] = symbol
+		}
+	}
+	return &statement{importType, nil, ""}
+}
+
+// visitStatement skips the test, which only the test command runs.
+func (t *testStatement) visitStatement(scope *scope) *statement {
+	return &statement{testType, nil, ""}
+}
+
+func (e *exportStatement) visitStatement(scope *scope) *statement {
+	return e.statement.visitStatement(scope)
+}
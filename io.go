@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	stdin = bufio.NewReader(os.Stdin)
+	// stdout is where the program prints to.
+	stdout io.Writer = os.Stdout
+)
+
+func ioBuiltin(name string, args []*expression) (*expression, error) {
+	switch name {
+	case "eprint":
+		print(os.Stderr, args)
+		return nil, nil
+	case "read_line":
+		if err := expectArguments(name, args, stringType, 0); err != nil {
+			return nil, err
+		}
+		line, err := stdin.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, fmt.Errorf("read_line: end of input")
+		} else if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("read_line: %s", err)
+		}
+		return &expression{stringType, strings.TrimRight(line, "\r\n")}, nil
+	case "eof":
+		if err := expectArguments(name, args, stringType, 0); err != nil {
+			return nil, err
+		}
+		_, err := stdin.Peek(1)
+		return &expression{booleanType, err != nil}, nil
+	case "write_file", "append_file":
+		if err := expectArguments(name, args, stringType, 2); err != nil {
+			return nil, err
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if name == "append_file" {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(args[0].value.(string), flags, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(args[1].value.(string)); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return nil, nil
+	}
+	if err := expectArguments(name, args, stringType, 1); err != nil {
+		return nil, err
+	}
+	path := args[0].value.(string)
+	switch name {
+	case "read_file":
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read_file: %s", err)
+		}
+		return &expression{stringType, string(bytes)}, nil
+	case "lines":
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("lines: %s", err)
+		}
+		var lines []*expression
+		text := strings.TrimSuffix(string(bytes), "\n")
+		if text != "" {
+			for _, line := range strings.Split(text, "\n") {
+				lines = append(lines, &expression{stringType, strings.TrimSuffix(line, "\r")})
+			}
+		}
+		return &expression{listType, lines}, nil
+	case "exists":
+		_, err := os.Stat(path)
+		return &expression{booleanType, err == nil}, nil
+	case "list_dir":
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("list_dir: %s", err)
+		}
+		var names []*expression
+		for _, info := range infos {
+			names = append(names, &expression{stringType, info.Name()})
+		}
+		return &expression{listType, names}, nil
+	}
+	return nil, fmt.Errorf("could not find fn: '%s'", name)
+}
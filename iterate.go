@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+)
+
+type (
+	// iterator produces the elements of an iterable value one at a time.
+	iterator interface {
+		next() (*expression, bool)
+	}
+
+	listIterator struct {
+		elements []*expression
+		index    int
+	}
+
+	// objectIterator iterates over an object whose class defines has_next
+	// and next methods.
+	objectIterator struct {
+		self *expression
+	}
+
+	mapKey struct {
+		typeValue expressionType
+		value     interface{}
+	}
+
+	mapValue struct {
+		keys   []*expression
+		values map[mapKey]*expression
+	}
+
+	rangeIterator struct {
+		current int
+		r       *rangeValue
+	}
+
+	rangeValue struct {
+		start int
+		end   int
+		step  int
+	}
+
+	stringIterator struct {
+		runes []rune
+		index int
+	}
+)
+
+func iterate(e *expression) iterator {
+	switch e.typeValue {
+	case listType:
+		return &listIterator{e.value.([]*expression), 0}
+	case mapType:
+		return &listIterator{e.value.(*mapValue).keys, 0}
+	case rangeType:
+		r := e.value.(*rangeValue)
+		return &rangeIterator{r.start, r}
+	case stringType:
+		return &stringIterator{[]rune(e.value.(string)), 0}
+	case objectType:
+		class := e.value.(*object).class
+		if findMethod(class, "has_next") != nil && findMethod(class, "next") != nil {
+			return &objectIterator{e}
+		}
+	}
+	fail("cannot iterate over %s", types[e.typeValue])
+	return nil
+}
+
+func (it *listIterator) next() (*expression, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+	it.index++
+	return it.elements[it.index-1], true
+}
+
+func (it *objectIterator) next() (*expression, bool) {
+	class := it.self.value.(*object).class
+	m, owner := methodIn(parentOf, class, "has_next")
+	hasNext := invoke(it.self, owner, m, nil)
+	if hasNext == nil || hasNext.typeValue != booleanType {
+		fail("%s.has_next must return boolean", class.name)
+	}
+	if !hasNext.value.(bool) {
+		return nil, false
+	}
+	m, owner = methodIn(parentOf, class, "next")
+	return invoke(it.self, owner, m, nil), true
+}
+
+func (it *rangeIterator) next() (*expression, bool) {
+	if it.r.step > 0 && it.current >= it.r.end || it.r.step < 0 && it.current <= it.r.end {
+		return nil, false
+	}
+	it.current += it.r.step
+	return &expression{numberType, it.current - it.r.step}, true
+}
+
+func (it *stringIterator) next() (*expression, bool) {
+	if it.index >= len(it.runes) {
+		return nil, false
+	}
+	it.index++
+	return &expression{stringType, string(it.runes[it.index-1])}, true
+}
+
+func newMapValue() *mapValue {
+	return &mapValue{nil, map[mapKey]*expression{}}
+}
+
+func newMapKey(e *expression) mapKey {
+	switch e.typeValue {
+	case numberType:
+		if f, ok := e.value.(float64); ok && f == math.Trunc(f) {
+			if n, ok := toInt(f); ok {
+				return mapKey{numberType, n}
+			}
+		}
+		return mapKey{e.typeValue, e.value}
+	case booleanType, stringType:
+		return mapKey{e.typeValue, e.value}
+	}
+	fail("invalid map key type: %s", types[e.typeValue])
+	return mapKey{}
+}
+
+func (m *mapValue) get(key *expression) *expression {
+	return m.values[newMapKey(key)]
+}
+
+func (m *mapValue) set(key *expression, value *expression) {
+	k := newMapKey(key)
+	if _, ok := m.values[k]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[k] = value
+}
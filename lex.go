@@ -11,6 +11,30 @@ import (
 	"unicode/utf8"
 )
 
+var keywords = []string{
+	"var",
+	"if",
+	"while",
+	"for",
+	"in",
+	"break",
+	"continue",
+	"fn",
+	"return",
+	"true",
+	"false",
+	"not",
+	"and",
+	"or",
+	"struct",
+	"class",
+	"super",
+	"enum",
+	"match",
+	"import",
+	"export",
+}
+
 type lexer struct {
 	out       chan string
 	pos       int
@@ -18,6 +42,9 @@ type lexer struct {
 	line      int
 	lineIndex int
 	text      string
+	// interpolations holds, for each expression embedded in a string
+	// being lexed, the depth of the braces open in it.
+	interpolations []int
 }
 
 func newLexer(bytes []byte) lexer {
@@ -56,6 +83,15 @@ func (lex *lexer) consume(pattern string, token string, args ...string) (string,
 	return text, nil
 }
 
+// skipLine skips to the end of the current line.
+func (lex *lexer) skipLine() {
+	if i := strings.IndexRune(lex.text[lex.pos:], '\n'); i >= 0 {
+		lex.pos += i
+	} else {
+		lex.pos = len(lex.text)
+	}
+}
+
 func (lex *lexer) newLine() {
 	lex.line++
 	lex.lineIndex = lex.pos
@@ -69,55 +105,115 @@ func (lex *lexer) emit(s string, args ...string) {
 	lex.out <- line
 }
 
-func (lex *lexer) consumeString() {
+// escapes maps the characters that may follow a backslash in a string to
+// the characters they stand for.
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+	'$':  '$',
+}
+
+// consumeString consumes a string literal, or the rest of an interpolated
+// string following an embedded expression when continued is true. An
+// expression embedded with ${ ends the token; the expression is then lexed
+// as code up to its closing brace.
+func (lex *lexer) consumeString(continued bool) {
 	var buf bytes.Buffer
-	var prev rune
+	start := lex.pos - lex.width
 	for {
 		c, err := lex.next()
-		if err != nil {
+		if err != nil || c == '"' {
 			break
 		}
-		if prev != '\\' && c == '"' {
-			break
+		if c == '$' && strings.HasPrefix(lex.text[lex.pos:], "{") {
+			lex.pos++
+			lex.width = lex.pos - start
+			if continued {
+				lex.emit("interpolationMiddle", buf.String())
+			} else {
+				lex.emit("interpolationStart", buf.String())
+			}
+			lex.interpolations = append(lex.interpolations, 0)
+			return
+		}
+		if c == '\\' {
+			e, err := lex.next()
+			if err != nil {
+				break
+			}
+			if escaped, ok := escapes[e]; ok {
+				c = escaped
+			} else {
+				buf.WriteRune(c)
+				c = e
+			}
 		}
-		prev = c
 		buf.WriteRune(c)
 	}
-	lex.emit("string", buf.String())
+	lex.width = lex.pos - start
+	if continued {
+		lex.emit("interpolationEnd", buf.String())
+	} else {
+		lex.emit("string", buf.String())
+	}
+}
+
+// brace tracks the brace c within embedded expressions, reporting whether
+// it closes one.
+func (lex *lexer) brace(c rune) bool {
+	n := len(lex.interpolations)
+	if n == 0 {
+		return false
+	}
+	if c == '{' {
+		lex.interpolations[n-1]++
+		return false
+	}
+	if lex.interpolations[n-1] == 0 {
+		lex.interpolations = lex.interpolations[:n-1]
+		return true
+	}
+	lex.interpolations[n-1]--
+	return false
 }
 
 func (lex *lexer) lex() {
+	if strings.HasPrefix(lex.text, "#!") {
+		lex.skipLine()
+	}
 	for lex.hasMore() {
-		lex.consume("var", "var")
-		lex.consume("if", "if")
-		lex.consume("while", "while")
-		lex.consume("break", "break")
-		lex.consume("continue", "continue")
-		lex.consume("fn", "fn")
-		lex.consume("return", "return")
-		lex.consume("true", "true")
-		lex.consume("false", "false")
+		lex.consume(`//[^\r\n]*`, "comment")
+		for _, keyword := range keywords {
+			lex.consume(keyword+`\b`, keyword)
+		}
 		lex.consume("==", "==")
+		lex.consume("=>", "=>")
 		lex.consume("!=", "!=")
 		lex.consume(">=", ">=")
 		lex.consume("<=", "<=")
-		lex.consume("not", "not")
-		lex.consume("and", "and")
-		lex.consume("or", "or")
 		lex.consume("[a-zA-Z_][a-zA-Z_0-9]*", "id")
-		lex.consume("[0-9]+", "number")
-		c, _ := lex.next()
+		lex.consume(`[0-9]+(\.[0-9]+)?`, "number")
+		c, err := lex.next()
+		if err != nil {
+			break
+		}
 		if c == '"' {
-			lex.consumeString()
+			lex.consumeString(false)
+		} else if (c == '{' || c == '}') && lex.brace(c) {
+			lex.consumeString(true)
 		} else if c == ';' {
 			lex.emit(";")
 		} else if c == '\n' {
 			lex.newLine()
-		} else if strings.ContainsRune("=+-*/(){}<>,", c) {
+		} else if strings.ContainsRune("=+-*/(){}[]<>,:.", c) {
 			lex.emit(string(c), string(c))
 		} else if !strings.ContainsRune(" \t\r\n", c) {
-			fmt.Fprintf(os.Stderr, "unrecognized char '%c' at line %d, column %d\n", c, lex.line+1, lex.pos-lex.width-lex.lineIndex+1)
-			os.Exit(1)
+			lex.emit("error", fmt.Sprintf("unrecognized char '%c'", c))
+			close(lex.out)
+			return
 		}
 	}
 
@@ -125,13 +221,34 @@ func (lex *lexer) lex() {
 	close(lex.out)
 }
 
-func lex(filepath string) <-chan string {
-	bytes, err := ioutil.ReadFile(filepath)
+func lex(file string) <-chan string {
+	return lexSource(source(file))
+}
+
+func lexSource(src []byte) <-chan string {
+	lex := newLexer(src)
+	go lex.lex()
+	return lex.out
+}
+
+// source returns the program in file, exiting if it cannot be read.
+func source(file string) []byte {
+	bytes, err := readSource(file)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	lex := newLexer(bytes)
-	go lex.lex()
-	return lex.out
+	return bytes
+}
+
+// readSource reads the program in file. The program is read from standard
+// input when file is "-" and taken from the -e flag when file is "-e".
+func readSource(file string) ([]byte, error) {
+	switch file {
+	case "-":
+		return ioutil.ReadAll(os.Stdin)
+	case "-e":
+		return []byte(*evalFlag), nil
+	}
+	return ioutil.ReadFile(file)
 }
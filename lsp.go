@@ -0,0 +1,933 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The language server speaks the Language Server Protocol over standard
+// input and output. A document is analyzed whenever it changes: its
+// syntax, type annotation and inference errors are published as
+// diagnostics, and the analysis of the last version that parsed answers
+// the other requests. Lines and characters are counted from 0 by the
+// protocol and from 1 by positions.
+
+type (
+	lspServer struct {
+		documents map[string]*document
+		shutdown  bool
+	}
+
+	lspRequest struct {
+		ID     *json.RawMessage `json:"id"`
+		Method string           `json:"method"`
+		Params json.RawMessage  `json:"params"`
+	}
+
+	lspResponse struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id"`
+		Result  interface{}      `json:"result"`
+	}
+
+	lspErrorResponse struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id"`
+		Error   *lspError        `json:"error"`
+	}
+
+	lspNotification struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+	}
+
+	lspError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+
+	lspLocation struct {
+		URI   string   `json:"uri"`
+		Range lspRange `json:"range"`
+	}
+
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+
+	lspTextEdit struct {
+		Range   lspRange `json:"range"`
+		NewText string   `json:"newText"`
+	}
+
+	lspSymbol struct {
+		Name           string       `json:"name"`
+		Detail         string       `json:"detail,omitempty"`
+		Kind           int          `json:"kind"`
+		Range          lspRange     `json:"range"`
+		SelectionRange lspRange     `json:"selectionRange"`
+		Children       []*lspSymbol `json:"children,omitempty"`
+	}
+
+	lspCompletion struct {
+		Label  string `json:"label"`
+		Kind   int    `json:"kind"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	// lspDocumentPosition holds the parameters of requests about a
+	// position in a document.
+	lspDocumentPosition struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		Position lspPosition `json:"position"`
+		Context  struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+		NewName        string `json:"newName"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+
+	document struct {
+		uri      string
+		path     string
+		text     string
+		analysis *analysis
+	}
+
+	// analysis links the names used in a module to their definitions.
+	analysis struct {
+		module      *module
+		inferrer    *inferrer
+		definitions []*definition
+		references  []*reference
+		variants    map[*enumVariant]*definition
+	}
+
+	// definition is a name declared at pos in the file path. The name is in
+	// scope from from to to.
+	definition struct {
+		name     string
+		pos      pos
+		path     string
+		node     interface{}
+		from, to pos
+	}
+
+	// reference is a use of a name at pos, or of a builtin when definition
+	// is nil.
+	reference struct {
+		pos
+		name       string
+		definition *definition
+	}
+
+	// resolver finds the definitions of the names in a module, declaring
+	// them in scopes as the parser does.
+	resolver struct {
+		*analysis
+		scope *scope
+		path  string
+		ids   []*identifier
+		end   pos
+	}
+)
+
+// Symbol and completion kinds of the protocol.
+const (
+	symbolClass      = 5
+	symbolMethod     = 6
+	symbolEnum       = 10
+	symbolFunction   = 12
+	symbolVariable   = 13
+	symbolStruct     = 23
+	symbolEnumMember = 22
+
+	completionFunction = 3
+	completionVariable = 6
+	completionClass    = 7
+	completionKeyword  = 14
+	completionEnum     = 13
+	completionConstant = 21
+	completionStruct   = 22
+	completionMember   = 20
+)
+
+var (
+	identifierPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z_0-9]*$`)
+	// memberPrefix matches the text before a member being completed.
+	memberPrefix = regexp.MustCompile(`([a-zA-Z_][a-zA-Z_0-9]*)\.[a-zA-Z_0-9]*$`)
+)
+
+func lspCommand(args []string) {
+	s := &lspServer{documents: map[string]*document{}}
+	for {
+		request := &lspRequest{}
+		err := readMessage(stdin, request)
+		if err == io.EOF {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if request.Method == "exit" {
+			if s.shutdown {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		result, lspErr := s.handle(request)
+		if request.ID == nil {
+			continue
+		}
+		if lspErr != nil {
+			writeMessage(&lspErrorResponse{"2.0", request.ID, lspErr})
+		} else {
+			writeMessage(&lspResponse{"2.0", request.ID, result})
+		}
+	}
+}
+
+// readMessage reads a message framed by a Content-Length header from in,
+// as both the language server and debug adapter protocols frame them, and
+// decodes its JSON body into message.
+func readMessage(in *bufio.Reader, message interface{}) error {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return fmt.Errorf("bad header '%s'", line)
+			}
+		}
+	}
+	if length < 0 {
+		return fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, message)
+}
+
+func writeMessage(message interface{}) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) handle(request *lspRequest) (interface{}, *lspError) {
+	var params lspDocumentPosition
+	if len(request.Params) > 0 {
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return nil, &lspError{-32602, err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	switch request.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"renameProvider":         true,
+				"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "lang"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.update(uri, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(uri, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.documents, uri)
+		writeMessage(&lspNotification{"2.0", "textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []lspDiagnostic{}}})
+		return nil, nil
+	}
+	if !strings.HasPrefix(request.Method, "textDocument/") {
+		if request.ID == nil {
+			return nil, nil
+		}
+		return nil, &lspError{-32601, fmt.Sprintf("unsupported method '%s'", request.Method)}
+	}
+	d, ok := s.documents[uri]
+	if !ok || d.analysis == nil {
+		return nil, nil
+	}
+	a := d.analysis
+	at := pos{params.Position.Line + 1, params.Position.Character + 1}
+	switch request.Method {
+	case "textDocument/definition":
+		if def, _ := a.lookup(d.path, at); def != nil {
+			return def.location(), nil
+		}
+		return nil, nil
+	case "textDocument/references":
+		locations := []lspLocation{}
+		if def, _ := a.lookup(d.path, at); def != nil {
+			if params.Context.IncludeDeclaration {
+				locations = append(locations, def.location())
+			}
+			for _, r := range a.references {
+				if r.definition == def {
+					locations = append(locations, lspLocation{d.uri, nameRange(r.pos, r.name)})
+				}
+			}
+		}
+		return locations, nil
+	case "textDocument/hover":
+		if text := a.hover(d.path, at); text != "" {
+			return map[string]interface{}{
+				"contents": map[string]string{"kind": "markdown", "value": "```\n" + text + "\n```"},
+			}, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		return a.symbols(), nil
+	case "textDocument/completion":
+		return a.complete(d, at), nil
+	case "textDocument/rename":
+		return a.rename(d, at, params.NewName)
+	}
+	if request.ID == nil {
+		return nil, nil
+	}
+	return nil, &lspError{-32601, fmt.Sprintf("unsupported method '%s'", request.Method)}
+}
+
+// update analyzes the new text of the document at uri and publishes its
+// diagnostics.
+func (s *lspServer) update(uri string, text string) {
+	d, ok := s.documents[uri]
+	if !ok {
+		d = &document{uri: uri, path: uriPath(uri)}
+		s.documents[uri] = d
+	}
+	d.text = text
+	diagnostics := d.analyze()
+	writeMessage(&lspNotification{"2.0", "textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics}})
+}
+
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: canonicalPath(path)}).String()
+}
+
+// analyze parses the document, keeping the analysis if it parses, and
+// returns its diagnostics.
+func (d *document) analyze() []lspDiagnostic {
+	modules = map[string]*module{}
+	m, err := parseDocument(d.path, d.text)
+	if err != nil {
+		if err.imported {
+			return []lspDiagnostic{diagnostic(pos{1, 1}, err.Error())}
+		}
+		return []lspDiagnostic{diagnostic(err.pos, err.message)}
+	}
+	diagnostics := []lspDiagnostic{}
+	seen := map[pos]bool{}
+	in := infer(m.block)
+	for _, message := range append(check(m.block), in.errors...) {
+		imported := false
+		for _, loaded := range modules {
+			imported = imported || strings.HasPrefix(message, loaded.path+": ")
+		}
+		if match := errorPosition.FindStringSubmatch(message); match != nil && !imported {
+			line, _ := strconv.Atoi(match[2])
+			column, _ := strconv.Atoi(match[3])
+			if !seen[pos{line, column}] {
+				seen[pos{line, column}] = true
+				diagnostics = append(diagnostics, diagnostic(pos{line, column}, match[1]))
+			}
+		}
+	}
+	d.analysis = resolve(m, in)
+	return diagnostics
+}
+
+// errorPosition matches the position errors end with.
+var errorPosition = regexp.MustCompile(`^(.*) at line (\d+), column (\d+)$`)
+
+func parseDocument(path string, text string) (m *module, err *syntaxError) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return parse(path, []byte(text)), nil
+}
+
+func diagnostic(p pos, message string) lspDiagnostic {
+	return lspDiagnostic{lspRange{p.lsp(), lspPosition{p.line - 1, p.column}}, 1, "lang", message}
+}
+
+func (p pos) lsp() lspPosition {
+	return lspPosition{p.line - 1, p.column - 1}
+}
+
+func nameRange(p pos, name string) lspRange {
+	return lspRange{p.lsp(), lspPosition{p.line - 1, p.column - 1 + len(name)}}
+}
+
+func (d *definition) location() lspLocation {
+	return lspLocation{pathURI(d.path), nameRange(d.pos, d.name)}
+}
+
+// identifiers lists the identifiers in src in order.
+func identifiers(src []byte) []*identifier {
+	var ids []*identifier
+	lexOut := lexSource(src)
+	for {
+		t := newTokenInfo(lexOut)
+		if t.symbol == "id" {
+			ids = append(ids, &identifier{pos{t.line, t.column}, t.value})
+		} else if t.symbol == "eof" || t.symbol == "error" {
+			for range lexOut {
+			}
+			return ids
+		}
+	}
+}
+
+func resolve(m *module, in *inferrer) *analysis {
+	a := &analysis{module: m, inferrer: in, variants: map[*enumVariant]*definition{}}
+	r := &resolver{a, nil, m.path, identifiers(m.source), m.block.end}
+	r.scope = constantScope(func(e *expression) interface{} { return nil })
+	r.block(m.block, nil)
+	return a
+}
+
+// find returns the position of the first identifier name at or after from.
+func (r *resolver) find(from pos, name string) pos {
+	i := sort.Search(len(r.ids), func(i int) bool { return !r.ids[i].pos.before(from) })
+	for ; i < len(r.ids); i++ {
+		if r.ids[i].value == name {
+			return r.ids[i].pos
+		}
+	}
+	return from
+}
+
+// next returns the position of the first identifier name after at.
+func (r *resolver) next(at pos, name string) pos {
+	return r.find(pos{at.line, at.column + 1}, name)
+}
+
+func (r *resolver) declare(name string, at pos, node interface{}, from pos) *definition {
+	d := &definition{name, at, r.path, node, from, r.end}
+	r.scope.declare(name, d)
+	r.definitions = append(r.definitions, d)
+	return d
+}
+
+func (r *resolver) use(name string, at pos) *definition {
+	var d *definition
+	if symbol := r.scope.resolve(name); symbol != nil {
+		d, _ = symbol.value.(*definition)
+	}
+	if _, builtin := builtinTypes[name]; d != nil || builtin {
+		r.references = append(r.references, &reference{at, name, d})
+	}
+	return d
+}
+
+// typeName resolves the annotation typeName following at, returning the
+// position it was found at.
+func (r *resolver) typeName(at pos, typeName string) pos {
+	if typeName == "" {
+		return at
+	}
+	p := r.next(at, typeName)
+	r.use(typeName, p)
+	return p
+}
+
+func (r *resolver) block(b *block, declare func()) {
+	scope, end := r.scope, r.end
+	r.scope, r.end = newScope(scope), b.end
+	if declare != nil {
+		declare()
+	}
+	for _, s := range b.statements {
+		if e, ok := s.(*exportStatement); ok {
+			s = e.statement
+		}
+		if f, ok := s.(*functionStatement); ok {
+			r.declare(f.name, r.find(f.pos, f.name), f, b.pos)
+		}
+	}
+	for _, s := range b.statements {
+		r.statement(s)
+	}
+	r.scope, r.end = scope, end
+}
+
+// function resolves the parameters and body of f.
+func (r *resolver) function(f *functionStatement) {
+	var parameters []pos
+	at := r.find(f.pos, f.name)
+	for i, parameter := range f.parameters {
+		at = r.next(at, parameter)
+		parameters = append(parameters, at)
+		at = r.typeName(at, f.parameterTypes[i])
+	}
+	r.typeName(at, f.returnType)
+	r.block(f.block, func() {
+		for i, parameter := range f.parameters {
+			r.declare(parameter, parameters[i], f, f.block.pos)
+		}
+	})
+}
+
+func (r *resolver) statement(s statementVisitor) {
+	switch s := s.(type) {
+	case *block:
+		r.block(s, nil)
+	case *declarationStatement:
+		r.expression(s.expression)
+		at := r.find(s.pos, s.id)
+		r.typeName(at, s.typeName)
+		r.declare(s.id, at, s, s.pos)
+	case *assignmentStatement:
+		r.use(s.id, s.pos)
+		r.expression(s.expression)
+	case *fieldAssignmentStatement:
+		r.expression(s.object)
+		r.expression(s.expression)
+	case *ifStatement:
+		r.expression(s.booleanExpression)
+		r.block(s.block, nil)
+	case *whileStatement:
+		r.expression(s.booleanExpression)
+		r.block(s.block, nil)
+	case *forStatement:
+		var key, value pos
+		at := s.pos
+		if s.label != "" {
+			at = pos{at.line, at.column + 1}
+		}
+		if s.key != "" {
+			key = r.find(at, s.key)
+			at = pos{key.line, key.column + 1}
+		}
+		value = r.find(at, s.value)
+		r.expression(s.collection)
+		r.block(s.block, func() {
+			if s.key != "" {
+				r.declare(s.key, key, s, s.block.pos)
+			}
+			r.declare(s.value, value, s, s.block.pos)
+		})
+	case *functionStatement:
+		r.function(s)
+	case *returnStatement:
+		if s.expression != nil {
+			r.expression(s.expression)
+		}
+	case *structStatement:
+		at := r.find(s.pos, s.name)
+		r.declare(s.name, at, s, s.pos)
+		for i, field := range s.fields {
+			at = r.typeName(r.next(at, field), s.fieldTypes[i])
+		}
+	case *classStatement:
+		at := r.find(s.pos, s.name)
+		r.declare(s.name, at, s, s.pos)
+		if s.parent != "" {
+			r.use(s.parent, r.next(at, s.parent))
+		}
+		for _, field := range s.fields {
+			r.expression(field.expression)
+			r.typeName(r.find(field.pos, field.id), field.typeName)
+		}
+		for _, method := range s.methods {
+			r.function(method)
+		}
+	case *enumStatement:
+		r.declare(s.name, r.find(s.pos, s.name), s, s.pos)
+		for _, v := range s.variants {
+			d := &definition{v.name, v.pos, r.path, v, s.pos, r.end}
+			r.variants[v] = d
+			r.definitions = append(r.definitions, d)
+			at := v.pos
+			for i, field := range v.fields {
+				at = r.typeName(r.next(at, field), v.fieldTypes[i])
+			}
+		}
+	case *importStatement:
+		ids := identifiers(s.module.source)
+		imported := &resolver{r.analysis, r.scope, s.module.path, ids, r.end}
+		for _, e := range s.module.exports {
+			imported.declare(e.name, imported.find(e.statement.position(), e.name), e.statement, s.pos)
+			if enum, ok := e.statement.(*enumStatement); ok {
+				for _, v := range enum.variants {
+					r.variants[v] = &definition{v.name, v.pos, s.module.path, v, s.pos, r.end}
+				}
+			}
+		}
+	case *exportStatement:
+		r.statement(s.statement)
+	case *testStatement:
+		r.block(s.block, nil)
+	case *callExpression:
+		r.expression(s)
+	case *matchExpression:
+		r.expression(s)
+	}
+}
+
+func (r *resolver) expression(e expressionVisitor) {
+	switch e := e.(type) {
+	case *booleanExpression:
+		r.expression(e.left)
+		r.expression(e.right)
+	case *logicalOperand:
+		r.expression(e.left)
+		r.expression(e.right)
+	case *term:
+		r.expression(e.left)
+		r.expression(e.right)
+	case *logicalNotExpression:
+		r.expression(e.booleanExpression)
+	case *callExpression:
+		if e.receiver != nil {
+			r.expression(e.receiver)
+		} else {
+			r.use(e.name, e.pos)
+		}
+		r.expressions(e.arguments)
+	case *identifier:
+		r.use(e.value, e.pos)
+	case *interpolationExpression:
+		r.expressions(e.expressions)
+	case *listLiteral:
+		r.expressions(e.elements)
+	case *mapLiteral:
+		r.expressions(e.keys)
+		r.expressions(e.values)
+	case *structLiteral:
+		r.use(e.name, e.pos)
+		r.expressions(e.values)
+	case *fieldExpression:
+		r.expression(e.object)
+	case *variantExpression:
+		r.variant(e.pos, e.enum, e.variant)
+		r.expressions(e.arguments)
+	case *matchExpression:
+		r.expression(e.subject)
+		for _, arm := range e.arms {
+			scope := r.scope
+			r.scope = newScope(scope)
+			r.pattern(arm.pattern, arm.pos)
+			if arm.guard != nil {
+				r.expression(arm.guard)
+			}
+			r.expression(arm.body)
+			r.scope = scope
+		}
+	}
+}
+
+func (r *resolver) expressions(expressions []expressionVisitor) {
+	for _, e := range expressions {
+		r.expression(e)
+	}
+}
+
+// variant resolves enum.name at at.
+func (r *resolver) variant(at pos, enum string, name string) {
+	d := r.use(enum, at)
+	if d == nil {
+		return
+	}
+	if e, ok := d.node.(*enumStatement); ok {
+		if v := e.variant(name); v != nil && r.variants[v] != nil {
+			r.references = append(r.references, &reference{r.next(at, name), name, r.variants[v]})
+		}
+	}
+}
+
+func (r *resolver) pattern(p patternVisitor, from pos) {
+	switch p := p.(type) {
+	case *bindingPattern:
+		r.declare(p.name, p.pos, p, from)
+	case *variantPattern:
+		r.variant(p.pos, p.enum, p.variant)
+		for _, pattern := range p.patterns {
+			r.pattern(pattern, from)
+		}
+	}
+}
+
+// lookup returns the definition of the name at p in the file path, and
+// the reference at p if the name is a use rather than the definition.
+func (a *analysis) lookup(path string, p pos) (*definition, *reference) {
+	within := func(at pos, name string) bool {
+		return at.line == p.line && at.column <= p.column && p.column <= at.column+len(name)
+	}
+	for _, r := range a.references {
+		if within(r.pos, r.name) {
+			return r.definition, r
+		}
+	}
+	for _, d := range a.definitions {
+		if d.path == path && within(d.pos, d.name) {
+			return d, nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *analysis) hover(path string, p pos) string {
+	d, r := a.lookup(path, p)
+	if d == nil {
+		if r != nil {
+			if t := builtinTypes[r.name]; t != 0 {
+				return fmt.Sprintf("builtin %s: %s", r.name, types[t])
+			}
+			return "builtin " + r.name
+		}
+		return ""
+	}
+	switch node := d.node.(type) {
+	case *functionStatement:
+		if node.name == d.name {
+			if _, ok := a.inferrer.schemes[node]; ok {
+				return a.inferrer.signature(node)
+			}
+			return "fn " + node.name
+		}
+	case *structStatement, *enumStatement:
+		f := &formatter{}
+		node.(statementVisitor).formatStatement(f)
+		return f.String()
+	case *classStatement:
+		if node.parent != "" {
+			return fmt.Sprintf("class %s : %s", node.name, node.parent)
+		}
+		return "class " + node.name
+	case *enumVariant:
+		f := &formatter{}
+		f.variant(node)
+		return f.String()
+	}
+	var t inferredType
+	if r != nil {
+		t = a.inferrer.types[r.pos]
+	} else if declaration, ok := d.node.(*declarationStatement); ok {
+		t = a.inferrer.types[declaration.pos]
+	}
+	for _, r := range a.references {
+		if t == nil && r.definition == d {
+			t = a.inferrer.types[r.pos]
+		}
+	}
+	if t == nil {
+		return d.name
+	}
+	return fmt.Sprintf("%s: %s", d.name, typeString(t, map[*typeVariable]string{}))
+}
+
+func (a *analysis) symbols() []*lspSymbol {
+	symbols := []*lspSymbol{}
+	for _, s := range a.module.block.statements {
+		if e, ok := s.(*exportStatement); ok {
+			s = e.statement
+		}
+		switch s := s.(type) {
+		case *functionStatement:
+			symbols = append(symbols, a.functionSymbol(s, symbolFunction))
+		case *declarationStatement:
+			symbols = append(symbols, a.symbol(s, s.id, symbolVariable, s.pos))
+		case *structStatement:
+			symbols = append(symbols, a.symbol(s, s.name, symbolStruct, s.pos))
+		case *classStatement:
+			class := a.symbol(s, s.name, symbolClass, s.pos)
+			for _, method := range s.methods {
+				m := a.functionSymbol(method, symbolMethod)
+				class.Children = append(class.Children, m)
+				class.Range.End = m.Range.End
+			}
+			symbols = append(symbols, class)
+		case *enumStatement:
+			enum := a.symbol(s, s.name, symbolEnum, s.pos)
+			for _, v := range s.variants {
+				variant := a.symbol(v, v.name, symbolEnumMember, v.pos)
+				enum.Children = append(enum.Children, variant)
+				enum.Range.End = variant.Range.End
+			}
+			symbols = append(symbols, enum)
+		}
+	}
+	return symbols
+}
+
+// symbol describes the symbol name declared by node at start.
+func (a *analysis) symbol(node interface{}, name string, kind int, start pos) *lspSymbol {
+	at := start
+	for _, d := range a.definitions {
+		if d.node == node && d.name == name {
+			at = d.pos
+		}
+	}
+	selection := nameRange(at, name)
+	return &lspSymbol{name, "", kind, lspRange{start.lsp(), selection.End}, selection, nil}
+}
+
+func (a *analysis) functionSymbol(f *functionStatement, kind int) *lspSymbol {
+	s := a.symbol(f, f.name, kind, f.pos)
+	s.Range.End = lspPosition{f.block.end.line - 1, f.block.end.column}
+	if _, ok := a.inferrer.schemes[f]; ok {
+		s.Detail = a.inferrer.signature(f)
+	}
+	return s
+}
+
+// complete lists the names in scope at p, or the variants of an enum when
+// p follows its name and a dot.
+func (a *analysis) complete(d *document, p pos) []lspCompletion {
+	items := []lspCompletion{}
+	lines := strings.Split(d.text, "\n")
+	var prefix string
+	if p.line <= len(lines) && p.column-1 <= len(lines[p.line-1]) {
+		prefix = lines[p.line-1][:p.column-1]
+	}
+	if match := memberPrefix.FindStringSubmatch(prefix); match != nil {
+		for _, def := range a.visible(p) {
+			if e, ok := def.node.(*enumStatement); ok && def.name == match[1] && def.name == e.name {
+				for _, v := range e.variants {
+					items = append(items, lspCompletion{v.name, completionMember, e.name})
+				}
+			}
+		}
+		return items
+	}
+	seen := map[string]bool{}
+	for _, def := range a.visible(p) {
+		if seen[def.name] {
+			continue
+		}
+		seen[def.name] = true
+		kind := completionVariable
+		switch node := def.node.(type) {
+		case *functionStatement:
+			if node.name == def.name {
+				kind = completionFunction
+			}
+		case *structStatement:
+			kind = completionStruct
+		case *classStatement:
+			kind = completionClass
+		case *enumStatement:
+			kind = completionEnum
+		}
+		items = append(items, lspCompletion{def.name, kind, a.hover(def.path, def.pos)})
+	}
+	var names []string
+	for name := range builtinTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		items = append(items, lspCompletion{name, completionFunction, "builtin"})
+	}
+	for name := range constants {
+		items = append(items, lspCompletion{name, completionConstant, "number"})
+	}
+	for _, keyword := range keywords {
+		items = append(items, lspCompletion{keyword, completionKeyword, ""})
+	}
+	return items
+}
+
+// visible lists the definitions in scope at p, innermost first.
+func (a *analysis) visible(p pos) []*definition {
+	var visible []*definition
+	for i := len(a.definitions) - 1; i >= 0; i-- {
+		d := a.definitions[i]
+		if !p.before(d.from) && !d.to.before(p) {
+			if _, ok := d.node.(*enumVariant); !ok {
+				visible = append(visible, d)
+			}
+		}
+	}
+	return visible
+}
+
+func (a *analysis) rename(d *document, p pos, name string) (interface{}, *lspError) {
+	def, _ := a.lookup(d.path, p)
+	if def == nil {
+		return nil, &lspError{-32602, "no symbol to rename"}
+	}
+	if !identifierPattern.MatchString(name) || indexOf(keywords, name) >= 0 {
+		return nil, &lspError{-32602, fmt.Sprintf("'%s' is not a valid name", name)}
+	}
+	if def.path != d.path {
+		return nil, &lspError{-32602, fmt.Sprintf("'%s' is declared in %s", def.name, def.path)}
+	}
+	edits := []lspTextEdit{{nameRange(def.pos, def.name), name}}
+	for _, r := range a.references {
+		if r.definition == def {
+			edits = append(edits, lspTextEdit{nameRange(r.pos, r.name), name})
+		}
+	}
+	return map[string]interface{}{"changes": map[string][]lspTextEdit{d.uri: edits}}, nil
+}
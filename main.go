@@ -8,26 +8,77 @@ import (
 )
 
 var (
-	lexFlag   = flag.Bool("lex", false, "lex only")
-	parseFlag = flag.Bool("parse", false, "parse only")
+	lexFlag          = flag.Bool("lex", false, "lex only")
+	parseFlag        = flag.Bool("parse", false, "parse only")
+	typesFlag        = flag.Bool("types", false, "print inferred types only")
+	evalFlag         = flag.String("e", "", "run the given program instead of a file")
+	coverFlag        = flag.Bool("cover", false, "report the share of statements run and branches taken")
+	coverProfileFlag = flag.String("coverprofile", "", "write a coverage profile of the program to the file")
+	profileFlag      = flag.Bool("profile", false, "report the time spent and calls made in each function and line")
+	pprofFlag        = flag.String("pprof", "", "write a profile of the program to the file in pprof format")
+	traceFlag        = flag.Bool("trace", false, "log each statement run, the values produced and the functions entered and returned from")
 )
 
+var commands = map[string]func(args []string){
+	"check": checkCommand,
+	"fmt":   fmtCommand,
+	"lsp":   lspCommand,
+	"debug": debugCommand,
+	"dap":   dapCommand,
+	"cover": coverCommand,
+	"test":  testCommand,
+}
+
 func main() {
+	defer exitOnError()
 	flag.Parse()
-	if flag.NArg() != 1 {
-		fmt.Fprintln(os.Stderr, "missing file")
-		os.Exit(2)
+	if command, ok := commands[flag.Arg(0)]; ok {
+		command(flag.Args()[1:])
+		return
+	}
+	file := "-e"
+	scriptArgs = flag.Args()
+	if *evalFlag == "" {
+		if flag.NArg() < 1 {
+			fmt.Fprintln(os.Stderr, "missing file")
+			os.Exit(2)
+		}
+		file = flag.Arg(0)
+		scriptArgs = flag.Args()[1:]
 	}
-	file := flag.Arg(0)
 	if *lexFlag {
 		debugLex(file)
 	} else if *parseFlag {
 		debugParse(file)
+	} else if *typesFlag {
+		debugTypes(file)
 	} else {
 		interpret(file)
 	}
 }
 
+// exitOnError reports a syntax or runtime error panicked with and exits,
+// with status 3 if an assertion failed and 1 otherwise, or exits with the
+// status the program passed to exit.
+func exitOnError() {
+	if r := recover(); r != nil {
+		switch err := r.(type) {
+		case *exitError:
+			os.Exit(err.code)
+		case *runtimeError:
+			fmt.Fprintln(os.Stderr, err)
+			if err.assertion {
+				os.Exit(3)
+			}
+			os.Exit(1)
+		case *syntaxError:
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		panic(r)
+	}
+}
+
 func debugLex(file string) {
 	lexer := lex(file)
 	for {
@@ -36,9 +87,45 @@ func debugLex(file string) {
 		if strings.Index(line, "eof") == 0 {
 			return
 		}
+		if strings.Index(line, "error") == 0 {
+			os.Exit(1)
+		}
 	}
 }
 
 func debugParse(file string) {
-	fmt.Fprintln(os.Stderr, parse(lex(file)))
+	m := load(file)
+	optimizeModules()
+	fmt.Fprintln(os.Stderr, m.block)
+}
+
+func debugTypes(file string) {
+	in := infer(load(file).block)
+	for _, f := range in.order {
+		fmt.Fprintln(os.Stderr, in.signature(f))
+	}
+	for _, name := range in.globals {
+		names := map[*typeVariable]string{}
+		fmt.Fprintf(os.Stderr, "var %s: %s\n", name, typeString(in.root.resolve(name).value, names))
+	}
+	for _, err := range in.errors {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(in.errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func checkCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "missing file")
+		os.Exit(2)
+	}
+	errors := check(load(args[0]).block)
+	for _, err := range errors {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errors) > 0 {
+		os.Exit(1)
+	}
 }
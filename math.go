@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Numbers are ints until an operation involves a fraction, when they become
+// float64s. Both have the type number.
+
+var (
+	constants = map[string]*expression{
+		"pi": {numberType, math.Pi},
+		"e":  {numberType, math.E},
+	}
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// constantScope returns a scope declaring the math constants, each as the
+// value value produces for it.
+func constantScope(value func(e *expression) interface{}) *scope {
+	scope := newScope(nil)
+	for name, e := range constants {
+		scope.declare(name, value(e))
+	}
+	return scope
+}
+
+func toFloat(v interface{}) float64 {
+	if n, ok := v.(int); ok {
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+// toInt converts f, a whole number, to an int, reporting whether it is in
+// the range of ints. It is not if f is infinite or NaN.
+func toInt(f float64) (int, bool) {
+	if f >= math.MinInt && f < -math.MinInt {
+		return int(f), true
+	}
+	return 0, false
+}
+
+func formatNumber(v interface{}) string {
+	if n, ok := v.(int); ok {
+		return strconv.Itoa(n)
+	}
+	return strconv.FormatFloat(v.(float64), 'g', -1, 64)
+}
+
+// compareNumbers returns -1, 0 or 1 as left is less than, equal to or
+// greater than right.
+func compareNumbers(left interface{}, right interface{}) int {
+	l, lok := left.(int)
+	r, rok := right.(int)
+	if !lok || !rok {
+		lf, rf := toFloat(left), toFloat(right)
+		switch {
+		case lf < rf:
+			return -1
+		case lf > rf:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func arithmetic(left interface{}, operator string, right interface{}) (*expression, error) {
+	l, lok := left.(int)
+	r, rok := right.(int)
+	if lok && rok {
+		switch operator {
+		case "+":
+			return &expression{numberType, l + r}, nil
+		case "-":
+			return &expression{numberType, l - r}, nil
+		case "*":
+			return &expression{numberType, l * r}, nil
+		case "/":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return &expression{numberType, l / r}, nil
+		}
+	}
+	lf, rf := toFloat(left), toFloat(right)
+	switch operator {
+	case "+":
+		return &expression{numberType, lf + rf}, nil
+	case "-":
+		return &expression{numberType, lf - rf}, nil
+	case "*":
+		return &expression{numberType, lf * rf}, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return &expression{numberType, lf / rf}, nil
+	}
+	return nil, fmt.Errorf("unrecognized operator")
+}
+
+func integerArguments(name string, args []*expression, count int) ([]int, error) {
+	if err := expectArguments(name, args, numberType, count); err != nil {
+		return nil, err
+	}
+	var ints []int
+	for _, arg := range args {
+		n, ok := arg.value.(int)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected integer, got %s", name, formatNumber(arg.value))
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+var floatFunctions = map[string]func(float64) float64{
+	"sqrt": math.Sqrt,
+	"sin":  math.Sin,
+	"cos":  math.Cos,
+	"tan":  math.Tan,
+	"asin": math.Asin,
+	"acos": math.Acos,
+	"atan": math.Atan,
+	"exp":  math.Exp,
+	"log":  math.Log,
+}
+
+var roundingFunctions = map[string]func(float64) float64{
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+}
+
+func mathBuiltin(name string, args []*expression) (*expression, error) {
+	if f, ok := floatFunctions[name]; ok {
+		if err := expectArguments(name, args, numberType, 1); err != nil {
+			return nil, err
+		}
+		x := toFloat(args[0].value)
+		switch {
+		case name == "sqrt" && x < 0, name == "log" && x <= 0, (name == "asin" || name == "acos") && math.Abs(x) > 1:
+			return nil, fmt.Errorf("%s: argument out of range: %s", name, formatNumber(args[0].value))
+		}
+		return &expression{numberType, f(x)}, nil
+	}
+	switch name {
+	case "abs":
+		if err := expectArguments(name, args, numberType, 1); err != nil {
+			return nil, err
+		}
+		if n, ok := args[0].value.(int); ok && n == math.MinInt {
+			// The least int has no int opposite.
+			return &expression{numberType, -float64(n)}, nil
+		} else if ok && n < 0 {
+			return &expression{numberType, -n}, nil
+		} else if ok {
+			return args[0], nil
+		}
+		return &expression{numberType, math.Abs(args[0].value.(float64))}, nil
+	case "min", "max":
+		if err := expectArguments(name, args, numberType, -1); err != nil {
+			return nil, err
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			c := compareNumbers(arg.value, result.value)
+			if name == "min" && c < 0 || name == "max" && c > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	case "pow":
+		if err := expectArguments(name, args, numberType, 2); err != nil {
+			return nil, err
+		}
+		base, bok := args[0].value.(int)
+		exponent, eok := args[1].value.(int)
+		if bok && eok && exponent >= 0 {
+			if result, ok := power(base, exponent); ok {
+				return &expression{numberType, result}, nil
+			}
+		}
+		return &expression{numberType, math.Pow(toFloat(args[0].value), toFloat(args[1].value))}, nil
+	case "atan2":
+		if err := expectArguments(name, args, numberType, 2); err != nil {
+			return nil, err
+		}
+		return &expression{numberType, math.Atan2(toFloat(args[0].value), toFloat(args[1].value))}, nil
+	case "floor", "ceil", "round":
+		if err := expectArguments(name, args, numberType, 1); err != nil {
+			return nil, err
+		}
+		if _, ok := args[0].value.(int); ok {
+			return args[0], nil
+		}
+		f := roundingFunctions[name](args[0].value.(float64))
+		if n, ok := toInt(f); ok {
+			return &expression{numberType, n}, nil
+		}
+		return &expression{numberType, f}, nil
+	case "mod":
+		n, err := integerArguments(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if n[1] == 0 {
+			return nil, fmt.Errorf("mod: division by zero")
+		}
+		m := n[0] % n[1]
+		if m != 0 && (m < 0) != (n[1] < 0) {
+			m += n[1]
+		}
+		return &expression{numberType, m}, nil
+	case "gcd":
+		n, err := integerArguments(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		a, b := n[0], n[1]
+		for b != 0 {
+			a, b = b, a%b
+		}
+		if a < 0 {
+			a = -a
+		}
+		return &expression{numberType, a}, nil
+	case "random":
+		if err := expectArguments(name, args, numberType, 0); err != nil {
+			return nil, err
+		}
+		return &expression{numberType, random.Float64()}, nil
+	case "random_int":
+		n, err := integerArguments(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if n[0] >= n[1] {
+			return nil, fmt.Errorf("random_int: empty range %d to %d", n[0], n[1])
+		}
+		return &expression{numberType, n[0] + random.Intn(n[1]-n[0])}, nil
+	case "seed":
+		n, err := integerArguments(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		random.Seed(int64(n[0]))
+		return nil, nil
+	}
+	return nil, fmt.Errorf("could not find fn: '%s'", name)
+}
+
+// power raises base to exponent by squaring, reporting false if the result
+// does not fit in an int.
+func power(base, exponent int) (int, bool) {
+	result := 1
+	for exponent > 0 {
+		var ok bool
+		if exponent&1 == 1 {
+			if result, ok = multiply(result, base); !ok {
+				return 0, false
+			}
+		}
+		if exponent >>= 1; exponent > 0 {
+			if base, ok = multiply(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
+
+// multiply multiplies a and b, reporting false if the product overflows.
+func multiply(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || a == -1 && b == math.MinInt || b == -1 && a == math.MinInt {
+		return 0, false
+	}
+	return c, true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// module is a parsed source file. A module is parsed and evaluated once,
+// however many times it is imported.
+type module struct {
+	path     string
+	source   []byte
+	block    *block
+	exports  []*exportStatement
+	comments []*comment
+	scope    *scope
+}
+
+var (
+	modules = map[string]*module{}
+	// loading lists the modules being parsed, each imported by the one
+	// before it.
+	loading []string
+	// searchPath lists the directories, from LANGPATH, that non-relative
+	// imports are looked up in after the directory of the importing file.
+	searchPath = filepath.SplitList(os.Getenv("LANGPATH"))
+)
+
+func load(file string) *module {
+	path := canonicalPath(file)
+	if m, ok := modules[path]; ok {
+		return m
+	}
+	m := parse(file, source(file))
+	modules[path] = m
+	return m
+}
+
+// parse parses the module in file from its source src, panicking with a
+// *syntaxError if it is malformed.
+func parse(file string, src []byte) *module {
+	return parseModule(file, src, false)
+}
+
+// parseUnresolved parses the module in file like parse, but records its
+// imports without loading the modules they name, for tools that only need
+// its syntax.
+func parseUnresolved(file string, src []byte) *module {
+	return parseModule(file, src, true)
+}
+
+func parseModule(file string, src []byte, unresolved bool) *module {
+	loading = append(loading, canonicalPath(file))
+	lexOut := lexSource(src)
+	defer func() {
+		if r := recover(); r != nil {
+			for range lexOut {
+			}
+			if e, ok := r.(*syntaxError); ok && len(loading) > 1 {
+				e.imported = true
+			}
+			loading = loading[:len(loading)-1]
+			panic(r)
+		}
+	}()
+	p := newParser(file, lexOut)
+	p.unresolved = unresolved
+	p.root = newScope(constantScope(func(e *expression) interface{} { return true }))
+	b := p.block(p.root)
+	loading = loading[:len(loading)-1]
+	return &module{file, src, b, p.exports, p.comments, nil}
+}
+
+func (m *module) evaluate() {
+	if m.scope == nil {
+		m.scope = newScope(constantScope(func(e *expression) interface{} { return e }))
+		m.block.visitStatement(m.scope)
+	}
+}
+
+func canonicalPath(file string) string {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	return path
+}
+
+// findModule resolves the import of name from the file from. Names starting
+// with ./ or ../ are relative to from; other names are looked up next to
+// from and then along the search path. The .txt extension may be omitted.
+func findModule(from string, name string) (string, bool) {
+	dirs := []string{filepath.Dir(from)}
+	if !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+		dirs = append(dirs, searchPath...)
+	}
+	for _, dir := range dirs {
+		for _, candidate := range []string{name, name + ".txt"} {
+			file := filepath.Join(dir, candidate)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				return file, true
+			}
+		}
+	}
+	return "", false
+}
+
+// importCycle describes the chain of imports leading back to file if it is
+// still being parsed, and returns "" otherwise.
+func importCycle(file string) string {
+	path := canonicalPath(file)
+	for i, l := range loading {
+		if l == path {
+			var chain []string
+			for _, l := range loading[i:] {
+				chain = append(chain, filepath.Base(l))
+			}
+			return strings.Join(append(chain, filepath.Base(path)), " -> ")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The optimizer rewrites the tree of each module loaded before it runs.
+// Operators whose operands are all literals are replaced by the literal
+// they evaluate to, unless evaluating them fails, which is left to happen
+// when the program runs. if and while statements whose condition folds to
+// false are removed, as are the statements of a block after one that
+// always leaves it. Programs whose coverage is measured are not optimized,
+// so that the code removed is reported as never run.
+
+// optimizeModules optimizes every module loaded.
+func optimizeModules() {
+	for _, m := range modules {
+		m.block = optimizeBlock(m.block)
+	}
+}
+
+func optimizeBlock(b *block) *block {
+	var statements []statementVisitor
+	for _, s := range b.statements {
+		if s = optimizeStatement(s); s == nil {
+			continue
+		}
+		statements = append(statements, s)
+		switch s.(type) {
+		case *returnStatement, *breakStatement, *continueStatement:
+			return &block{b.pos, statements, b.end}
+		}
+	}
+	return &block{b.pos, statements, b.end}
+}
+
+// optimizeStatement optimizes s, returning nil if it never does anything.
+func optimizeStatement(s statementVisitor) statementVisitor {
+	switch s := s.(type) {
+	case *declarationStatement:
+		s.expression = fold(s.expression)
+	case *assignmentStatement:
+		s.expression = fold(s.expression)
+	case *fieldAssignmentStatement:
+		s.object, s.expression = fold(s.object), fold(s.expression)
+	case *ifStatement:
+		s.booleanExpression = fold(s.booleanExpression)
+		if isFalse(s.booleanExpression) {
+			return nil
+		}
+		s.block = optimizeBlock(s.block)
+	case *whileStatement:
+		s.booleanExpression = fold(s.booleanExpression)
+		if isFalse(s.booleanExpression) {
+			return nil
+		}
+		s.block = optimizeBlock(s.block)
+	case *forStatement:
+		s.collection = fold(s.collection)
+		s.block = optimizeBlock(s.block)
+	case *functionStatement:
+		s.block = optimizeBlock(s.block)
+	case *returnStatement:
+		if s.expression != nil {
+			s.expression = fold(s.expression)
+		}
+	case *classStatement:
+		for _, field := range s.fields {
+			field.expression = fold(field.expression)
+		}
+		for _, method := range s.methods {
+			method.block = optimizeBlock(method.block)
+		}
+	case *exportStatement:
+		s.statement = optimizeStatement(s.statement)
+	case *testStatement:
+		s.block = optimizeBlock(s.block)
+	case *callExpression:
+		return fold(s).(statementVisitor)
+	case *matchExpression:
+		return fold(s).(statementVisitor)
+	}
+	return s
+}
+
+func isFalse(e expressionVisitor) bool {
+	b, ok := e.(*booleanLiteral)
+	return ok && !b.value
+}
+
+// fold replaces the constant expressions in e by their values.
+func fold(e expressionVisitor) expressionVisitor {
+	switch e := e.(type) {
+	case *booleanExpression:
+		e.left = fold(e.left)
+		if e.right == nil {
+			return e.left
+		}
+		// false and x is false and true or x is true, whatever x is, as x
+		// is never evaluated.
+		if b, ok := e.left.(*booleanLiteral); ok && (e.operator == "and" || e.operator == "or") && b.value == (e.operator == "or") {
+			return b
+		}
+		e.right = fold(e.right)
+		if isLiteral(e.left) && isLiteral(e.right) {
+			return evaluateConstant(e)
+		}
+	case *logicalOperand:
+		e.left = fold(e.left)
+		if e.right == nil {
+			return e.left
+		}
+		e.right = fold(e.right)
+		if isLiteral(e.left) && isLiteral(e.right) {
+			return evaluateConstant(e)
+		}
+	case *term:
+		e.left = fold(e.left)
+		if e.right == nil {
+			return e.left
+		}
+		e.right = fold(e.right)
+		if isLiteral(e.left) && isLiteral(e.right) {
+			return evaluateConstant(e)
+		}
+	case *logicalNotExpression:
+		e.booleanExpression = fold(e.booleanExpression)
+		if isLiteral(e.booleanExpression) {
+			return evaluateConstant(e)
+		}
+	case *interpolationExpression:
+		constant := true
+		for i, part := range e.expressions {
+			e.expressions[i] = fold(part)
+			constant = constant && isLiteral(e.expressions[i])
+		}
+		if constant {
+			return evaluateConstant(e)
+		}
+	case *callExpression:
+		// The arguments of assertions are kept as written, as a failure
+		// shows their source.
+		if e.receiver == nil && assertions[e.name] {
+			return e
+		}
+		if e.receiver != nil {
+			e.receiver = fold(e.receiver)
+		}
+		foldAll(e.arguments)
+	case *listLiteral:
+		foldAll(e.elements)
+	case *mapLiteral:
+		foldAll(e.keys)
+		foldAll(e.values)
+	case *structLiteral:
+		foldAll(e.values)
+	case *fieldExpression:
+		e.object = fold(e.object)
+	case *variantExpression:
+		foldAll(e.arguments)
+	case *matchExpression:
+		e.subject = fold(e.subject)
+		for _, arm := range e.arms {
+			if arm.guard != nil {
+				arm.guard = fold(arm.guard)
+			}
+			arm.body = fold(arm.body)
+		}
+	}
+	return e
+}
+
+func foldAll(expressions []expressionVisitor) {
+	for i, e := range expressions {
+		expressions[i] = fold(e)
+	}
+}
+
+func isLiteral(e expressionVisitor) bool {
+	switch e.(type) {
+	case *numberLiteral, *stringLiteral, *booleanLiteral:
+		return true
+	}
+	return false
+}
+
+// evaluateConstant evaluates e, whose operands are literals, to a literal,
+// returning e itself if evaluating it fails.
+func evaluateConstant(e expressionVisitor) expressionVisitor {
+	v, err := tryExpression(e, nil)
+	if err != nil {
+		return e
+	}
+	pos := e.position()
+	switch v.typeValue {
+	case numberType:
+		if n, ok := v.value.(int); ok {
+			return &numberLiteral{pos, strconv.Itoa(n)}
+		}
+		f := v.value.(float64)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return e
+		}
+		text := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		return &numberLiteral{pos, text}
+	case stringType:
+		return &stringLiteral{pos, v.value.(string)}
+	case booleanType:
+		return &booleanLiteral{pos, v.value.(bool)}
+	}
+	return e
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOptimizeParse checks that -parse shows each program of
+// test/good/optimize optimized as its .parse file records.
+func TestOptimizeParse(t *testing.T) {
+	files, err := filepath.Glob("test/good/optimize/*.parse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .parse files")
+	}
+	for _, file := range files {
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reset()
+		m := load(strings.TrimSuffix(file, ".parse") + ".txt")
+		optimizeModules()
+		if got := m.block.String() + "\n"; got != string(want) {
+			t.Errorf("%s:\ngot  %s\nwant %s", file, got, want)
+		}
+	}
+}
@@ -9,8 +9,22 @@ import (
 
 type (
 	parser struct {
-		token  *token
-		lexOut <-chan string
+		token     *token
+		lexOut    <-chan string
+		loops     []string
+		functions int
+		class     string
+		file      string
+		root      *scope
+		exports   []*exportStatement
+		tests     []*testStatement
+		comments  []*comment
+		// ahead holds the tokens peeked at after token.
+		ahead []*token
+		// unresolved is set when imports are recorded without loading the
+		// modules they name. The names those export are then unknown, so
+		// names not declared are taken to be imported.
+		unresolved bool
 	}
 	token struct {
 		symbol string
@@ -18,10 +32,60 @@ type (
 		column int
 		value  string
 	}
+	// syntaxError is an error found while lexing or parsing. The parser
+	// panics with it, leaving the caller to report it. imported is set if
+	// file is a module imported by the program parsed, which the error then
+	// names.
+	syntaxError struct {
+		pos
+		file     string
+		message  string
+		imported bool
+	}
 )
 
-func newParser(lexOut <-chan string) *parser {
-	return &parser{newTokenInfo(lexOut), lexOut}
+func (e *syntaxError) Error() string {
+	if e.imported {
+		return fmt.Sprintf("%s: %s at line %d, column %d", e.file, e.message, e.line, e.column)
+	}
+	return fmt.Sprintf("%s at line %d, column %d", e.message, e.line, e.column)
+}
+
+func newParser(file string, lexOut <-chan string) *parser {
+	p := &parser{nil, lexOut, nil, 0, "", file, nil, nil, nil, nil, nil, false}
+	p.next()
+	return p
+}
+
+// next moves on to the next token.
+func (p *parser) next() {
+	if len(p.ahead) > 0 {
+		p.token, p.ahead = p.ahead[0], p.ahead[1:]
+		return
+	}
+	p.token = p.read()
+}
+
+// peek returns the token n tokens after the current one.
+func (p *parser) peek(n int) *token {
+	for len(p.ahead) < n {
+		p.ahead = append(p.ahead, p.read())
+	}
+	return p.ahead[n-1]
+}
+
+// read reads the next token from the lexer, keeping the comments before
+// it.
+func (p *parser) read() *token {
+	t := newTokenInfo(p.lexOut)
+	for t.symbol == "comment" {
+		p.comments = append(p.comments, &comment{pos{t.line, t.column}, t.value})
+		t = newTokenInfo(p.lexOut)
+	}
+	if t.symbol == "error" {
+		p.errorf(pos{t.line, t.column}, "%s", t.value)
+	}
+	return t
 }
 
 func newTokenInfo(lexOut <-chan string) *token {
@@ -52,20 +116,28 @@ func (p *parser) accept(expected string) bool {
 
 func (p *parser) expect(expected string) string {
 	if p.token.symbol != expected {
-		fmt.Fprintf(os.Stderr, "expected '%s', got '%s' at line %d, column %d\n", expected, p.token.symbol, p.token.line, p.token.column)
-		os.Exit(1)
+		p.errorf(p.pos(), "expected '%s', got '%s'", expected, p.token.symbol)
 	}
 	value := p.token.value
-	p.token = newTokenInfo(p.lexOut)
+	p.next()
 	return value
 }
 
+func (p *parser) errorf(pos pos, format string, args ...interface{}) {
+	panic(&syntaxError{pos, p.file, fmt.Sprintf(format, args...), false})
+}
+
+func (p *parser) pos() pos {
+	return pos{p.token.line, p.token.column}
+}
+
 func (p *parser) block(scope *scope) *block {
 	var statements []statementVisitor
+	pos := p.pos()
 	for !p.accept("eof") && !p.accept("}") {
 		statements = append(statements, p.statement(scope))
 	}
-	return &block{statements}
+	return &block{pos, statements, p.pos()}
 }
 
 func (p *parser) statement(scope *scope) statementVisitor {
@@ -74,7 +146,9 @@ func (p *parser) statement(scope *scope) statementVisitor {
 	} else if p.accept("if") {
 		return p.ifStatement(scope)
 	} else if p.accept("while") {
-		return p.whileStatement(scope)
+		return p.whileStatement(scope, p.pos(), "")
+	} else if p.accept("for") {
+		return p.forStatement(scope, p.pos(), "")
 	} else if p.accept("break") {
 		return p.breakStatement(scope)
 	} else if p.accept("continue") {
@@ -83,105 +157,657 @@ func (p *parser) statement(scope *scope) statementVisitor {
 		return p.functionStatement(scope)
 	} else if p.accept("return") {
 		return p.returnStatement(scope)
+	} else if p.accept("id") && p.token.value == "test" && p.peek(1).symbol == "string" {
+		// test is not a keyword, starting a test only before its name.
+		return p.testStatement(scope)
 	} else if p.accept("id") {
 		var v statementVisitor
+		pos := p.pos()
 		id := p.expect("id")
-		if p.accept("=") {
-			v = p.assignment(scope, id)
+		if p.accept(":") {
+			return p.labeledStatement(scope, pos, id)
+		} else if p.accept("=") {
+			v = p.assignment(scope, pos, id)
 		} else if p.accept("(") {
-			v = p.callExpression(scope, id)
+			v = p.postfixStatement(scope, pos, p.callExpression(scope, pos, nil, id))
+		} else if p.accept(".") {
+			v = p.postfixStatement(scope, pos, p.identifier(scope, pos, id))
 		}
 		p.expect(";")
 		return v
+	} else if p.accept("super") {
+		v := p.postfixStatement(scope, p.pos(), p.superCall(scope))
+		p.expect(";")
+		return v
+	} else if p.accept("struct") {
+		return p.structStatement(scope)
+	} else if p.accept("class") {
+		return p.classStatement(scope)
+	} else if p.accept("enum") {
+		return p.enumStatement(scope)
+	} else if p.accept("match") {
+		return p.matchExpression(scope)
+	} else if p.accept("import") {
+		return p.importStatement(scope)
+	} else if p.accept("export") {
+		return p.exportStatement(scope)
 	} else {
-		p.expect("var|if|while|fn|return")
+		p.expect("var|if|while|for|fn|return|struct|class|enum|match|import|export|test")
 		return nil
 	}
 }
 
 func (p *parser) declaration(scope *scope) *declarationStatement {
+	pos := p.pos()
 	p.expect("var")
 	id := p.expect("id")
+	typeName := p.typeAnnotation(scope)
 	p.expect("=")
 	n := p.booleanExpression(scope)
 	p.expect(";")
 	scope.declare(id, true)
-	return &declarationStatement{id, n}
+	return &declarationStatement{pos, id, typeName, n}
+}
+
+func (p *parser) importStatement(scope *scope) *importStatement {
+	pos := p.pos()
+	p.expect("import")
+	if scope != p.root {
+		p.errorf(pos, "import outside top level")
+	}
+	pathPos := p.pos()
+	path := p.expect("string")
+	p.expect(";")
+	if p.unresolved {
+		return &importStatement{pos, path, nil}
+	}
+	file, ok := findModule(p.file, path)
+	if !ok {
+		p.errorf(pathPos, "cannot find module '%s'", path)
+	}
+	if cycle := importCycle(file); cycle != "" {
+		p.errorf(pathPos, "import cycle: %s", cycle)
+	}
+	m := load(file)
+	for _, e := range m.exports {
+		switch s := e.statement.(type) {
+		case *declarationStatement:
+			scope.declare(e.name, true)
+		case *structStatement, *classStatement, *enumStatement:
+			scope.declare(e.name, s)
+		}
+	}
+	return &importStatement{pos, path, m}
+}
+
+func (p *parser) exportStatement(scope *scope) *exportStatement {
+	var name string
+	pos := p.pos()
+	p.expect("export")
+	if scope != p.root {
+		p.errorf(pos, "export outside top level")
+	}
+	s := p.statement(scope)
+	switch s := s.(type) {
+	case *declarationStatement:
+		name = s.id
+	case *functionStatement:
+		name = s.name
+	case *structStatement:
+		name = s.name
+	case *classStatement:
+		name = s.name
+	case *enumStatement:
+		name = s.name
+	default:
+		p.errorf(pos, "only declarations can be exported")
+	}
+	for _, e := range p.exports {
+		if e.name == name {
+			p.errorf(pos, "duplicate export '%s'", name)
+		}
+	}
+	e := &exportStatement{pos, name, s}
+	p.exports = append(p.exports, e)
+	return e
+}
+
+func (p *parser) testStatement(scope *scope) *testStatement {
+	pos := p.pos()
+	p.expect("id")
+	if scope != p.root {
+		p.errorf(pos, "test outside top level")
+	}
+	name := p.expect("string")
+	for _, t := range p.tests {
+		if t.name == name {
+			p.errorf(pos, "duplicate test '%s'", name)
+		}
+	}
+	p.expect("{")
+	block := p.block(newScope(scope))
+	p.expect("}")
+	t := &testStatement{pos, name, block}
+	p.tests = append(p.tests, t)
+	return t
+}
+
+func (p *parser) typeAnnotation(scope *scope) string {
+	if !p.accept(":") {
+		return ""
+	}
+	p.expect(":")
+	pos := p.pos()
+	typeName := p.expect("id")
+	if !p.typeDeclared(scope, typeName) && typeNamed(typeName) == 0 && !p.imported(scope, typeName) {
+		p.errorf(pos, "unrecognized type '%s'", typeName)
+	}
+	return typeName
+}
+
+func (p *parser) typeDeclared(scope *scope, name string) bool {
+	if symbol := scope.resolve(name); symbol != nil {
+		switch symbol.value.(type) {
+		case *structStatement, *classStatement, *enumStatement:
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) classStatement(scope *scope) *classStatement {
+	var fields []*declarationStatement
+	var methods []*functionStatement
+	pos := p.pos()
+	p.expect("class")
+	name := p.expect("id")
+	var parent string
+	if p.accept(":") {
+		p.expect(":")
+		parentPos := p.pos()
+		parent = p.expect("id")
+		if symbol := scope.resolve(parent); symbol == nil && !p.unresolved {
+			p.errorf(parentPos, "unrecognized class '%s'", parent)
+		} else if symbol != nil {
+			if _, ok := symbol.value.(*classStatement); !ok {
+				p.errorf(parentPos, "'%s' is not a class", parent)
+			}
+		}
+	}
+	c := &classStatement{pos, name, parent, nil, nil, pos}
+	scope.declare(name, c)
+	classScope := newScope(scope)
+	classScope.declare("self", true)
+	class := p.class
+	p.class = name
+	p.expect("{")
+	for !p.accept("}") {
+		if p.accept("fn") {
+			methods = append(methods, p.functionStatement(classScope))
+		} else {
+			fields = append(fields, p.declaration(newScope(scope)))
+		}
+	}
+	c.end = p.pos()
+	p.expect("}")
+	p.class = class
+	c.fields, c.methods = fields, methods
+	return c
+}
+
+func (p *parser) superCall(scope *scope) *callExpression {
+	pos := p.pos()
+	p.expect("super")
+	if p.class == "" {
+		p.errorf(pos, "super outside class")
+	}
+	p.expect(".")
+	namePos := p.pos()
+	name := p.expect("id")
+	return p.callExpression(scope, namePos, &superExpression{pos, p.class}, name)
+}
+
+// imported reports whether name, which is not declared in scope, is taken
+// to be imported from a module that was not loaded.
+func (p *parser) imported(scope *scope, name string) bool {
+	return p.unresolved && scope.resolve(name) == nil
+}
+
+func (p *parser) structNamed(scope *scope, name string) (*structStatement, bool) {
+	if symbol := scope.resolve(name); symbol != nil {
+		s, ok := symbol.value.(*structStatement)
+		return s, ok
+	}
+	return nil, false
+}
+
+func (p *parser) structStatement(scope *scope) *structStatement {
+	var fields, fieldTypes []string
+	pos := p.pos()
+	p.expect("struct")
+	name := p.expect("id")
+	p.expect("{")
+	for !p.accept("}") {
+		fieldPos := p.pos()
+		field := p.expect("id")
+		if indexOf(fields, field) >= 0 {
+			p.errorf(fieldPos, "duplicate field '%s' in struct %s", field, name)
+		}
+		fields = append(fields, field)
+		fieldTypes = append(fieldTypes, p.typeAnnotation(scope))
+		if !p.accept("}") {
+			p.expect(",")
+		}
+	}
+	p.expect("}")
+	s := &structStatement{pos, name, fields, fieldTypes}
+	scope.declare(name, s)
+	return s
+}
+
+// structLiteral parses a literal of the struct name, declared by s, or
+// imported from a module that was not loaded if s is nil.
+func (p *parser) structLiteral(scope *scope, pos pos, name string, s *structStatement) *structLiteral {
+	var fields []string
+	var values []expressionVisitor
+	p.expect("{")
+	for !p.accept("}") {
+		fieldPos := p.pos()
+		field := p.expect("id")
+		if s != nil && indexOf(s.fields, field) < 0 {
+			p.errorf(fieldPos, "unrecognized field '%s' in struct %s", field, name)
+		}
+		if indexOf(fields, field) >= 0 {
+			p.errorf(fieldPos, "duplicate field '%s' in struct %s", field, name)
+		}
+		p.expect(":")
+		fields = append(fields, field)
+		values = append(values, p.booleanExpression(scope))
+		if !p.accept("}") {
+			p.expect(",")
+		}
+	}
+	end := p.pos()
+	p.expect("}")
+	if s != nil {
+		for _, field := range s.fields {
+			if indexOf(fields, field) < 0 {
+				p.errorf(pos, "missing field '%s' in struct %s", field, name)
+			}
+		}
+	}
+	return &structLiteral{pos, name, fields, values, end}
+}
+
+func (p *parser) enumNamed(scope *scope, name string) (*enumStatement, bool) {
+	if symbol := scope.resolve(name); symbol != nil {
+		e, ok := symbol.value.(*enumStatement)
+		return e, ok
+	}
+	return nil, false
+}
+
+func (p *parser) enumStatement(scope *scope) *enumStatement {
+	pos := p.pos()
+	p.expect("enum")
+	name := p.expect("id")
+	e := &enumStatement{pos, name, nil, pos}
+	scope.declare(name, e)
+	p.expect("{")
+	for !p.accept("}") {
+		var fields, fieldTypes []string
+		variantPos := p.pos()
+		variant := p.expect("id")
+		if e.variant(variant) != nil {
+			p.errorf(variantPos, "duplicate variant '%s' in enum %s", variant, name)
+		}
+		if p.accept("(") {
+			p.expect("(")
+			for !p.accept(")") {
+				fieldPos := p.pos()
+				field := p.expect("id")
+				if indexOf(fields, field) >= 0 {
+					p.errorf(fieldPos, "duplicate field '%s' in variant %s.%s", field, name, variant)
+				}
+				fields = append(fields, field)
+				fieldTypes = append(fieldTypes, p.typeAnnotation(scope))
+				if !p.accept(")") {
+					p.expect(",")
+				}
+			}
+			p.expect(")")
+		}
+		e.variants = append(e.variants, &enumVariant{variantPos, variant, fields, fieldTypes})
+		if !p.accept("}") {
+			p.expect(",")
+		}
+	}
+	e.end = p.pos()
+	p.expect("}")
+	return e
+}
+
+// variantName parses the '.Variant' following the name of enum e.
+func (p *parser) variantName(e *enumStatement) *enumVariant {
+	p.expect(".")
+	pos := p.pos()
+	name := p.expect("id")
+	v := e.variant(name)
+	if v == nil {
+		p.errorf(pos, "unrecognized variant '%s' in enum %s", name, e.name)
+	}
+	return v
+}
+
+func (p *parser) variantExpression(scope *scope, pos pos, e *enumStatement) *variantExpression {
+	var arguments []expressionVisitor
+	end := pos
+	v := p.variantName(e)
+	if p.accept("(") || len(v.fields) > 0 {
+		call := p.callExpression(scope, pos, nil, v.name)
+		arguments, end = call.arguments, call.end
+	}
+	if len(arguments) != len(v.fields) {
+		p.errorf(pos, "variant %s.%s expects %d arguments, got %d", e.name, v.name, len(v.fields), len(arguments))
+	}
+	return &variantExpression{pos, e.name, v.name, arguments, end}
+}
+
+func (p *parser) matchExpression(scope *scope) *matchExpression {
+	var arms []*matchArm
+	pos := p.pos()
+	p.expect("match")
+	subject := p.booleanExpression(scope)
+	p.expect("{")
+	for !p.accept("}") {
+		var guard expressionVisitor
+		armPos := p.pos()
+		armScope := newScope(scope)
+		pattern := p.pattern(armScope)
+		if p.accept("if") {
+			p.expect("if")
+			guard = p.booleanExpression(armScope)
+		}
+		p.expect("=>")
+		arms = append(arms, &matchArm{armPos, pattern, guard, p.booleanExpression(armScope)})
+		if !p.accept("}") {
+			p.expect(",")
+		}
+	}
+	end := p.pos()
+	p.expect("}")
+	p.checkExhaustive(scope, pos, arms)
+	return &matchExpression{pos, subject, arms, end}
+}
+
+func (p *parser) pattern(scope *scope) patternVisitor {
+	pos := p.pos()
+	if p.accept("id") {
+		id := p.expect("id")
+		if id == "_" {
+			return &wildcardPattern{pos}
+		}
+		if e, ok := p.enumNamed(scope, id); ok {
+			v := p.variantName(e)
+			patterns := p.variantPatterns(scope, len(v.fields) > 0)
+			if len(patterns) != len(v.fields) {
+				p.errorf(pos, "variant %s.%s expects %d patterns, got %d", e.name, v.name, len(v.fields), len(patterns))
+			}
+			return &variantPattern{pos, e.name, v.name, patterns}
+		}
+		if p.imported(scope, id) && p.accept(".") {
+			p.expect(".")
+			variant := p.expect("id")
+			return &variantPattern{pos, id, variant, p.variantPatterns(scope, false)}
+		}
+		if _, ok := scope.symbols[id]; ok {
+			p.errorf(pos, "duplicate binding '%s'", id)
+		}
+		scope.declare(id, true)
+		return &bindingPattern{pos, id}
+	} else if p.accept("number") || p.accept("string") || p.accept("true") || p.accept("false") {
+		return &literalPattern{pos, p.primary(scope)}
+	}
+	p.expect("id|number|string|true|false")
+	return nil
+}
+
+// variantPatterns parses the patterns in parentheses matching the payload
+// of a variant, which are required if payload is set.
+func (p *parser) variantPatterns(scope *scope, payload bool) []patternVisitor {
+	var patterns []patternVisitor
+	if p.accept("(") || payload {
+		p.expect("(")
+		for !p.accept(")") {
+			patterns = append(patterns, p.pattern(scope))
+			if !p.accept(")") {
+				p.expect(",")
+			}
+		}
+		p.expect(")")
+	}
+	return patterns
+}
+
+// checkExhaustive reports an error unless the arms of a match over an enum
+// cover each of its variants. An unguarded arm covers its variant when all
+// of its payload patterns match anything; an unguarded binding or wildcard
+// covers every variant.
+func (p *parser) checkExhaustive(scope *scope, pos pos, arms []*matchArm) {
+	var enum *enumStatement
+	covered := map[string]bool{}
+	for _, arm := range arms {
+		switch pattern := arm.pattern.(type) {
+		case *variantPattern:
+			enum, _ = p.enumNamed(scope, pattern.enum)
+			if arm.guard == nil && irrefutable(pattern.patterns) {
+				covered[pattern.variant] = true
+			}
+		case *bindingPattern, *wildcardPattern:
+			if arm.guard == nil {
+				return
+			}
+		}
+	}
+	if enum == nil {
+		return
+	}
+	for _, v := range enum.variants {
+		if !covered[v.name] {
+			p.errorf(pos, "non-exhaustive match: missing %s.%s", enum.name, v.name)
+		}
+	}
+}
+
+func irrefutable(patterns []patternVisitor) bool {
+	for _, pattern := range patterns {
+		switch pattern.(type) {
+		case *bindingPattern, *wildcardPattern:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// postfixStatement parses the rest of a statement starting with e, which
+// must end in a call or in an assignment to a field.
+func (p *parser) postfixStatement(scope *scope, pos pos, e expressionVisitor) statementVisitor {
+	switch e := p.postfix(scope, e).(type) {
+	case *fieldExpression:
+		p.expect("=")
+		return &fieldAssignmentStatement{pos, e.object, e.field, p.booleanExpression(scope)}
+	case *callExpression:
+		return e
+	}
+	p.expect("=")
+	return nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
 }
 
 func (p *parser) ifStatement(scope *scope) *ifStatement {
+	pos := p.pos()
 	p.expect("if")
 	b := p.booleanExpression(scope)
 	p.expect("{")
 	block := p.block(newScope(scope))
 	p.expect("}")
-	return &ifStatement{b, block}
+	return &ifStatement{pos, b, block}
+}
+
+func (p *parser) labeledStatement(scope *scope, pos pos, label string) statementVisitor {
+	p.expect(":")
+	if p.accept("for") {
+		return p.forStatement(scope, pos, label)
+	}
+	return p.whileStatement(scope, pos, label)
 }
 
-func (p *parser) whileStatement(scope *scope) *whileStatement {
+func (p *parser) whileStatement(scope *scope, pos pos, label string) *whileStatement {
 	p.expect("while")
 	b := p.booleanExpression(scope)
 	p.expect("{")
-	block := p.block(newScope(scope))
+	block := p.loopBlock(newScope(scope), label)
+	p.expect("}")
+	return &whileStatement{pos, label, b, block}
+}
+
+func (p *parser) forStatement(scope *scope, pos pos, label string) *forStatement {
+	var key string
+	p.expect("for")
+	value := p.expect("id")
+	if p.accept(",") {
+		p.expect(",")
+		key, value = value, p.expect("id")
+	}
+	p.expect("in")
+	collection := p.booleanExpression(scope)
+	p.expect("{")
+	newScope := newScope(scope)
+	if key != "" {
+		newScope.declare(key, true)
+	}
+	newScope.declare(value, true)
+	block := p.loopBlock(newScope, label)
 	p.expect("}")
-	return &whileStatement{b, block}
+	return &forStatement{pos, label, key, value, collection, block}
+}
+
+func (p *parser) loopBlock(scope *scope, label string) *block {
+	p.loops = append(p.loops, label)
+	block := p.block(scope)
+	p.loops = p.loops[:len(p.loops)-1]
+	return block
 }
 
 func (p *parser) breakStatement(scope *scope) *breakStatement {
+	pos := p.pos()
 	p.expect("break")
+	label := p.loopLabel()
+	p.checkLoop("break", label, pos)
 	p.expect(";")
-	return &breakStatement{}
+	return &breakStatement{pos, label}
 }
 
 func (p *parser) continueStatement(scope *scope) *continueStatement {
+	pos := p.pos()
 	p.expect("continue")
+	label := p.loopLabel()
+	p.checkLoop("continue", label, pos)
 	p.expect(";")
-	return &continueStatement{}
+	return &continueStatement{pos, label}
+}
+
+func (p *parser) loopLabel() string {
+	if p.accept("id") {
+		return p.expect("id")
+	}
+	return ""
+}
+
+func (p *parser) checkLoop(keyword string, label string, pos pos) {
+	if len(p.loops) == 0 {
+		p.errorf(pos, "%s outside loop", keyword)
+	}
+	if label == "" {
+		return
+	}
+	for _, l := range p.loops {
+		if l == label {
+			return
+		}
+	}
+	p.errorf(pos, "unrecognized label '%s'", label)
 }
 
 func (p *parser) functionStatement(scope *scope) *functionStatement {
-	var parameters []string
+	var parameters, parameterTypes []string
+	var positions []pos
+	pos := p.pos()
 	p.expect("fn")
 	name := p.expect("id")
 	p.expect("(")
 	if p.accept("id") {
+		positions = append(positions, p.pos())
 		parameters = append(parameters, p.expect("id"))
+		parameterTypes = append(parameterTypes, p.typeAnnotation(scope))
 		for {
 			if !p.accept(",") {
 				break
 			}
 			p.expect(",")
+			positions = append(positions, p.pos())
 			parameters = append(parameters, p.expect("id"))
+			parameterTypes = append(parameterTypes, p.typeAnnotation(scope))
 		}
 	}
+	parametersEnd := p.pos()
 	p.expect(")")
+	returnType := p.typeAnnotation(scope)
 	p.expect("{")
 	newScope := newScope(scope)
 	for _, p := range parameters {
 		newScope.declare(p, true)
 	}
+	loops := p.loops
+	p.loops = nil
+	p.functions++
 	block := p.block(newScope)
+	p.functions--
+	p.loops = loops
 	p.expect("}")
-	return &functionStatement{name, parameters, block}
+	return &functionStatement{pos, name, parameters, parameterTypes, positions, parametersEnd, returnType, block}
 }
 
 func (p *parser) returnStatement(scope *scope) *returnStatement {
+	if p.functions == 0 {
+		p.errorf(p.pos(), "return outside function")
+	}
+	pos := p.pos()
 	p.expect("return")
 	if p.accept(";") {
 		p.expect(";")
-		return &returnStatement{nil}
+		return &returnStatement{pos, nil}
 	}
 	b := p.booleanExpression(scope)
 	p.expect(";")
-	return &returnStatement{b}
+	return &returnStatement{pos, b}
 }
 
-func (p *parser) assignment(scope *scope, id string) *assignmentStatement {
+func (p *parser) assignment(scope *scope, pos pos, id string) *assignmentStatement {
 	p.expect("=")
-	return &assignmentStatement{id, p.booleanExpression(scope)}
+	return &assignmentStatement{pos, id, p.booleanExpression(scope)}
 }
 
-func (p *parser) callExpression(scope *scope, id string) *callExpression {
+func (p *parser) callExpression(scope *scope, pos pos, receiver expressionVisitor, id string) *callExpression {
 	var arguments []expressionVisitor
 	p.expect("(")
 	for {
@@ -193,16 +819,18 @@ func (p *parser) callExpression(scope *scope, id string) *callExpression {
 			p.expect(",")
 		}
 	}
+	end := p.pos()
 	p.expect(")")
-	return &callExpression{id, arguments}
+	return &callExpression{pos, receiver, id, arguments, end, p.file}
 }
 
 func (p *parser) booleanExpression(scope *scope) expressionVisitor {
 	b := p.andExpression(scope)
 	for {
 		if p.accept("or") {
+			pos := p.pos()
 			p.expect("or")
-			b = &booleanExpression{b, "or", p.andExpression(scope)}
+			b = &booleanExpression{pos, b, "or", p.andExpression(scope)}
 		} else {
 			return b
 		}
@@ -213,8 +841,9 @@ func (p *parser) andExpression(scope *scope) expressionVisitor {
 	b := p.condition(scope)
 	for {
 		if p.accept("and") {
+			pos := p.pos()
 			p.expect("and")
-			b = &booleanExpression{b, "and", p.condition(scope)}
+			b = &booleanExpression{pos, b, "and", p.condition(scope)}
 		} else {
 			return b
 		}
@@ -224,6 +853,7 @@ func (p *parser) andExpression(scope *scope) expressionVisitor {
 func (p *parser) condition(scope *scope) expressionVisitor {
 	var operator string
 	left := p.logicalOperand(scope)
+	pos := p.pos()
 	if p.accept("==") {
 		operator = p.expect("==")
 	} else if p.accept("!=") {
@@ -239,18 +869,19 @@ func (p *parser) condition(scope *scope) expressionVisitor {
 	} else {
 		return left
 	}
-	return &booleanExpression{left, operator, p.logicalOperand(scope)}
+	return &booleanExpression{pos, left, operator, p.logicalOperand(scope)}
 }
 
 func (p *parser) logicalOperand(scope *scope) expressionVisitor {
 	e := p.term(scope)
 	for {
+		pos := p.pos()
 		if p.accept("+") {
 			p.expect("+")
-			e = &logicalOperand{e, "+", p.term(scope)}
+			e = &logicalOperand{pos, e, "+", p.term(scope)}
 		} else if p.accept("-") {
 			p.expect("-")
-			e = &logicalOperand{e, "-", p.term(scope)}
+			e = &logicalOperand{pos, e, "-", p.term(scope)}
 		} else {
 			return e
 		}
@@ -260,12 +891,13 @@ func (p *parser) logicalOperand(scope *scope) expressionVisitor {
 func (p *parser) term(scope *scope) expressionVisitor {
 	t := p.logicalNotExpression(scope)
 	for {
+		pos := p.pos()
 		if p.accept("*") {
 			p.expect("*")
-			t = &term{t, "*", p.logicalNotExpression(scope)}
+			t = &term{pos, t, "*", p.logicalNotExpression(scope)}
 		} else if p.accept("/") {
 			p.expect("/")
-			t = &term{t, "/", p.logicalNotExpression(scope)}
+			t = &term{pos, t, "/", p.logicalNotExpression(scope)}
 		} else {
 			return t
 		}
@@ -274,45 +906,154 @@ func (p *parser) term(scope *scope) expressionVisitor {
 
 func (p *parser) logicalNotExpression(scope *scope) expressionVisitor {
 	if p.accept("not") {
+		pos := p.pos()
 		p.expect("not")
-		return &logicalNotExpression{p.logicalNotExpression(scope)}
+		return &logicalNotExpression{pos, p.logicalNotExpression(scope)}
 	}
 	return p.atom(scope)
 }
 
 func (p *parser) atom(scope *scope) expressionVisitor {
+	return p.postfix(scope, p.primary(scope))
+}
+
+func (p *parser) postfix(scope *scope, e expressionVisitor) expressionVisitor {
+	for p.accept(".") {
+		p.expect(".")
+		pos := p.pos()
+		name := p.expect("id")
+		if p.accept("(") {
+			e = p.callExpression(scope, pos, e, name)
+		} else {
+			e = &fieldExpression{pos, e, name}
+		}
+	}
+	return e
+}
+
+// fieldAhead reports whether the brace that is the current token is
+// followed by a field and its value, starting a struct literal rather than
+// a block, whose statements may only start with a label before a loop.
+func (p *parser) fieldAhead() bool {
+	if p.peek(1).symbol != "id" || p.peek(2).symbol != ":" {
+		return false
+	}
+	next := p.peek(3).symbol
+	return next != "while" && next != "for"
+}
+
+func (p *parser) identifier(scope *scope, pos pos, id string) *identifier {
+	symbol := scope.resolve(id)
+	if symbol == nil && p.unresolved {
+		return &identifier{pos, id}
+	} else if symbol == nil {
+		p.errorf(pos, "unrecognized var '%s'", id)
+	}
+	switch symbol.value.(type) {
+	case *structStatement:
+		p.errorf(pos, "struct %s used as value", id)
+	case *classStatement:
+		p.errorf(pos, "class %s used as value", id)
+	case *enumStatement:
+		p.errorf(pos, "enum %s used as value", id)
+	}
+	return &identifier{pos, id}
+}
+
+func (p *parser) primary(scope *scope) expressionVisitor {
+	pos := p.pos()
 	if p.accept("id") {
-		line, column := p.token.line, p.token.column
 		id := p.expect("id")
 		if p.accept("(") {
-			return p.callExpression(scope, id)
+			return p.callExpression(scope, pos, nil, id)
+		}
+		if s, ok := p.structNamed(scope, id); ok && p.accept("{") {
+			return p.structLiteral(scope, pos, id, s)
+		}
+		if p.imported(scope, id) && p.accept("{") && p.fieldAhead() {
+			return p.structLiteral(scope, pos, id, nil)
 		}
-		if scope.resolve(id) == nil {
-			fmt.Fprintf(os.Stderr, "unrecognized var '%s' at line %d, column %d\n", id, line, column)
-			os.Exit(1)
+		if e, ok := p.enumNamed(scope, id); ok {
+			return p.variantExpression(scope, pos, e)
 		}
-		return &identifier{id}
+		return p.identifier(scope, pos, id)
 	} else if p.accept("number") {
-		return &numberLiteral{p.expect("number")}
+		return &numberLiteral{pos, p.expect("number")}
 	} else if p.accept("string") {
-		return &stringLiteral{p.expect("string")}
+		return &stringLiteral{pos, p.expect("string")}
+	} else if p.accept("interpolationStart") {
+		return p.interpolation(scope)
 	} else if p.accept("true") {
 		p.expect("true")
-		return &booleanLiteral{true}
+		return &booleanLiteral{pos, true}
 	} else if p.accept("false") {
 		p.expect("false")
-		return &booleanLiteral{false}
+		return &booleanLiteral{pos, false}
+	} else if p.accept("super") {
+		return p.superCall(scope)
+	} else if p.accept("match") {
+		return p.matchExpression(scope)
 	} else if p.accept("(") {
 		p.expect("(")
 		n := p.booleanExpression(scope)
 		p.expect(")")
 		return n
+	} else if p.accept("[") {
+		return p.listLiteral(scope)
+	} else if p.accept("{") {
+		return p.mapLiteral(scope)
 	} else {
-		p.expect("id|number|string|true|false")
+		p.expect("id|number|string|true|false|[|{")
 		return nil
 	}
 }
 
-func parse(lexOut <-chan string) *block {
-	return newParser(lexOut).block(newScope(nil))
+func (p *parser) interpolation(scope *scope) *interpolationExpression {
+	i := &interpolationExpression{p.pos(), []string{p.expect("interpolationStart")}, nil}
+	for {
+		i.expressions = append(i.expressions, p.booleanExpression(scope))
+		if p.accept("interpolationEnd") {
+			i.segments = append(i.segments, p.expect("interpolationEnd"))
+			return i
+		}
+		i.segments = append(i.segments, p.expect("interpolationMiddle"))
+	}
+}
+
+func (p *parser) listLiteral(scope *scope) *listLiteral {
+	var elements []expressionVisitor
+	pos := p.pos()
+	p.expect("[")
+	for {
+		if p.accept("]") {
+			break
+		}
+		elements = append(elements, p.booleanExpression(scope))
+		if !p.accept("]") {
+			p.expect(",")
+		}
+	}
+	end := p.pos()
+	p.expect("]")
+	return &listLiteral{pos, elements, end}
+}
+
+func (p *parser) mapLiteral(scope *scope) *mapLiteral {
+	var keys, values []expressionVisitor
+	pos := p.pos()
+	p.expect("{")
+	for {
+		if p.accept("}") {
+			break
+		}
+		keys = append(keys, p.booleanExpression(scope))
+		p.expect(":")
+		values = append(values, p.booleanExpression(scope))
+		if !p.accept("}") {
+			p.expect(",")
+		}
+	}
+	end := p.pos()
+	p.expect("}")
+	return &mapLiteral{pos, keys, values, end}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+func printfBuiltin(name string, args []*expression) (*expression, error) {
+	if name == "write" {
+		for _, arg := range args {
+			fmt.Fprint(stdout, display(arg))
+		}
+		return nil, nil
+	}
+	if len(args) == 0 || args[0].typeValue != stringType {
+		return nil, fmt.Errorf("%s: expected format string", name)
+	}
+	s, err := formatValues(name, args[0].value.(string), args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if name == "printf" {
+		fmt.Fprint(stdout, s)
+		return nil, nil
+	}
+	return &expression{stringType, s}, nil
+}
+
+// formatValues formats args following the printf-style format. A verb is % and,
+// optionally, the flags - + space and 0, a width and a precision, followed
+// by d, x, o or b for integers, f, e or g for numbers, t for booleans, s or
+// v for any value, or % for a percent sign.
+func formatValues(name string, format string, args []*expression) (string, error) {
+	var buf bytes.Buffer
+	runes := []rune(format)
+	next := 0
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			buf.WriteRune(runes[i])
+			continue
+		}
+		start := i
+		for i++; i < len(runes) && strings.ContainsRune("-+ 0", runes[i]); i++ {
+		}
+		for ; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+		}
+		if i < len(runes) && runes[i] == '.' {
+			for i++; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+			}
+		}
+		if i == len(runes) {
+			return "", fmt.Errorf("%s: incomplete verb '%s'", name, string(runes[start:]))
+		}
+		spec, verb := string(runes[start:i]), runes[i]
+		if verb == '%' {
+			buf.WriteRune('%')
+			continue
+		}
+		if next == len(args) {
+			return "", fmt.Errorf("%s: missing argument for '%s%c'", name, spec, verb)
+		}
+		arg := args[next]
+		next++
+		switch verb {
+		case 'd', 'x', 'o', 'b':
+			n, ok := arg.value.(int)
+			if arg.typeValue != numberType || !ok {
+				return "", fmt.Errorf("%s: '%s%c' expects integer, got %s", name, spec, verb, describe(arg))
+			}
+			buf.WriteString(fmt.Sprintf(spec+string(verb), n))
+		case 'f', 'e', 'g':
+			if arg.typeValue != numberType {
+				return "", fmt.Errorf("%s: '%s%c' expects number, got %s", name, spec, verb, describe(arg))
+			}
+			buf.WriteString(fmt.Sprintf(spec+string(verb), toFloat(arg.value)))
+		case 't':
+			if arg.typeValue != booleanType {
+				return "", fmt.Errorf("%s: '%s%c' expects boolean, got %s", name, spec, verb, describe(arg))
+			}
+			buf.WriteString(fmt.Sprintf(spec+string(verb), arg.value.(bool)))
+		case 's', 'v':
+			buf.WriteString(fmt.Sprintf(spec+"s", display(arg)))
+		default:
+			return "", fmt.Errorf("%s: unrecognized verb '%s%c'", name, spec, verb)
+		}
+	}
+	if next < len(args) {
+		return "", fmt.Errorf("%s: too many arguments for format, %d unused", name, len(args)-next)
+	}
+	return buf.String(), nil
+}
+
+// describe names the type of e in errors, showing the value of numbers
+// that are not of the expected kind.
+func describe(e *expression) string {
+	if e.typeValue == numberType {
+		return formatNumber(e.value)
+	}
+	return types[e.typeValue]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// scriptArgs holds the command-line arguments following the script's path.
+var scriptArgs []string
+
+// exitError is panicked by exit, so that the program unwinds, letting the
+// reports of its observers be written, before the process exits with code.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit(%d) called", e.code)
+}
+
+func processBuiltin(name string, args []*expression) (*expression, error) {
+	switch name {
+	case "args":
+		if err := expectArguments(name, args, stringType, 0); err != nil {
+			return nil, err
+		}
+		var values []*expression
+		for _, arg := range scriptArgs {
+			values = append(values, &expression{stringType, arg})
+		}
+		return &expression{listType, values}, nil
+	case "env":
+		if err := expectArguments(name, args, stringType, 1); err != nil {
+			return nil, err
+		}
+		return &expression{stringType, os.Getenv(args[0].value.(string))}, nil
+	case "exit":
+		if len(args) == 0 {
+			panic(&exitError{0})
+		}
+		code, err := integerArguments(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if code[0] < 0 || code[0] > 255 {
+			return nil, fmt.Errorf("exit: code out of range: %d", code[0])
+		}
+		panic(&exitError{code[0]})
+	}
+	return nil, fmt.Errorf("could not find fn: '%s'", name)
+}
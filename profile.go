@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// profiler is an observer that attributes the time between one event
+	// and the next to the statement running and the calls leading to it,
+	// counting the statements run and the calls made. Code at the top level
+	// of a module runs in the main function.
+	profiler struct {
+		modules   map[statementVisitor]*module
+		main      *module
+		frames    []*profileFrame
+		functions map[*functionStatement]*functionProfile
+		lines     map[location]*lineProfile
+		samples   map[string]*profileSample
+		start     time.Time
+		last      time.Time
+	}
+
+	// profileFrame is a function being run, at line.
+	profileFrame struct {
+		function *functionStatement
+		line     location
+	}
+
+	functionProfile struct {
+		name  string
+		calls int
+		self  time.Duration
+		total time.Duration
+		// active counts the calls of the function being run, so that the
+		// total time of recursive calls is counted once, from entered.
+		active  int
+		entered time.Time
+	}
+
+	lineProfile struct {
+		location
+		hits int
+		self time.Duration
+	}
+
+	// profileSample is the count of statements run and the time spent with
+	// the same frames being run.
+	profileSample struct {
+		frames []profileFrame
+		count  int64
+		time   time.Duration
+	}
+
+	// protobuf encodes protocol buffer messages, as pprof reads them.
+	protobuf struct {
+		bytes.Buffer
+	}
+)
+
+// hotSpots is the number of lines the table of the profile reports.
+const hotSpots = 20
+
+func newProfiler(m *module) *profiler {
+	now := time.Now()
+	p := &profiler{map[statementVisitor]*module{}, m, []*profileFrame{{nil, location{m.path, 0}}},
+		map[*functionStatement]*functionProfile{nil: {name: "main", calls: 1, active: 1, entered: now}},
+		map[location]*lineProfile{}, map[string]*profileSample{}, now, now}
+	for _, loaded := range modules {
+		collectStatements(loaded.block, loaded, p.modules)
+	}
+	return p
+}
+
+// charge attributes the time since the last event to the frames being run.
+func (p *profiler) charge(now time.Time) {
+	elapsed := now.Sub(p.last)
+	p.last = now
+	top := p.frames[len(p.frames)-1]
+	p.functions[top.function].self += elapsed
+	if l, ok := p.lines[top.line]; ok {
+		l.self += elapsed
+	}
+	p.sample().time += elapsed
+}
+
+// sample returns the sample of the frames being run.
+func (p *profiler) sample() *profileSample {
+	var key strings.Builder
+	for _, f := range p.frames {
+		fmt.Fprintf(&key, "%p %s;", f.function, f.line)
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &profileSample{}
+		for _, f := range p.frames {
+			s.frames = append(s.frames, *f)
+		}
+		p.samples[key.String()] = s
+	}
+	return s
+}
+
+func (p *profiler) statement(s statementVisitor, scope *scope) {
+	p.charge(time.Now())
+	m, ok := p.modules[s]
+	if !ok {
+		m = p.main
+	}
+	l := location{m.path, s.position().line}
+	p.frames[len(p.frames)-1].line = l
+	if _, ok := p.lines[l]; !ok {
+		p.lines[l] = &lineProfile{location: l}
+	}
+	p.lines[l].hits++
+	p.sample().count++
+}
+
+func (p *profiler) result(s statementVisitor, value *expression) {}
+
+func (p *profiler) branch(s statementVisitor, taken bool) {}
+
+func (p *profiler) enter(f *functionStatement, scope *scope) {
+	now := time.Now()
+	p.charge(now)
+	fp, ok := p.functions[f]
+	if !ok {
+		fp = &functionProfile{name: f.name}
+		p.functions[f] = fp
+	}
+	fp.calls++
+	if fp.active == 0 {
+		fp.entered = now
+	}
+	fp.active++
+	m, ok := p.modules[f]
+	if !ok {
+		m = p.main
+	}
+	// Until the first statement of the body runs, the call is at it, or at
+	// the closing brace of an empty body, rather than at the declaration.
+	line := f.block.end.line
+	if len(f.block.statements) > 0 {
+		line = f.block.statements[0].position().line
+	}
+	p.frames = append(p.frames, &profileFrame{f, location{m.path, line}})
+}
+
+func (p *profiler) exit(f *functionStatement, result *expression) {
+	now := time.Now()
+	p.charge(now)
+	p.frames = p.frames[:len(p.frames)-1]
+	fp := p.functions[f]
+	fp.active--
+	if fp.active == 0 {
+		fp.total += now.Sub(fp.entered)
+	}
+}
+
+// report ends the profile, printing its table to standard error if table
+// is set and writing it in pprof format to file if it is not "".
+func (p *profiler) report(table bool, file string) {
+	now := time.Now()
+	p.charge(now)
+	top := p.functions[nil]
+	top.total = now.Sub(top.entered)
+	if table {
+		p.table(os.Stderr)
+	}
+	if file != "" {
+		if err := p.writePprof(file, now); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// table lists the functions by the time spent in them, then the lines
+// where the most time was spent.
+func (p *profiler) table(w io.Writer) {
+	var functions []*functionProfile
+	for _, f := range p.functions {
+		functions = append(functions, f)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		return a.self > b.self || a.self == b.self && a.name < b.name
+	})
+	fmt.Fprintf(w, "%-24s %10s %12s %12s\n", "function", "calls", "self", "total")
+	for _, f := range functions {
+		fmt.Fprintf(w, "%-24s %10d %12s %12s\n", f.name, f.calls, duration(f.self), duration(f.total))
+	}
+	var lines []*lineProfile
+	for _, l := range p.lines {
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		return a.self > b.self || a.self == b.self && (a.path < b.path || a.path == b.path && a.line < b.line)
+	})
+	if len(lines) > hotSpots {
+		lines = lines[:hotSpots]
+	}
+	fmt.Fprintf(w, "\n%-24s %10s %12s\n", "line", "hits", "self")
+	for _, l := range lines {
+		fmt.Fprintf(w, "%-24s %10d %12s\n", l.location, l.hits, duration(l.self))
+	}
+}
+
+func duration(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
+
+// writePprof writes the profile to file as a gzipped profile.proto
+// message, whose samples are the statements run and the nanoseconds spent
+// in each call stack.
+func (p *profiler) writePprof(file string, now time.Time) error {
+	indexes := map[string]uint64{"": 0}
+	table := []string{""}
+	str := func(s string) uint64 {
+		if i, ok := indexes[s]; ok {
+			return i
+		}
+		indexes[s] = uint64(len(table))
+		table = append(table, s)
+		return indexes[s]
+	}
+	type functionKey struct {
+		name, path string
+	}
+	functions := map[functionKey]uint64{}
+	locations := map[profileFrame]uint64{}
+	var out, body protobuf
+	valueType := func(field int, kind, unit string) {
+		var v protobuf
+		v.uint(1, str(kind))
+		v.uint(2, str(unit))
+		out.message(field, v.Bytes())
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "time", "nanoseconds")
+	for _, s := range p.samples {
+		var ids []uint64
+		for i := len(s.frames) - 1; i >= 0; i-- {
+			f := s.frames[i]
+			id, ok := locations[f]
+			if !ok {
+				name, start := "main", 0
+				if f.function != nil {
+					name, start = f.function.name, f.function.position().line
+				}
+				key := functionKey{name, f.line.path}
+				fid, ok := functions[key]
+				if !ok {
+					fid = uint64(len(functions) + 1)
+					functions[key] = fid
+					var fn protobuf
+					fn.uint(1, fid)
+					fn.uint(2, str(name))
+					fn.uint(3, str(name))
+					fn.uint(4, str(f.line.path))
+					fn.uint(5, uint64(start))
+					body.message(5, fn.Bytes())
+				}
+				id = uint64(len(locations) + 1)
+				locations[f] = id
+				var line, loc protobuf
+				line.uint(1, fid)
+				line.uint(2, uint64(f.line.line))
+				loc.uint(1, id)
+				loc.message(4, line.Bytes())
+				body.message(4, loc.Bytes())
+			}
+			ids = append(ids, id)
+		}
+		var sample protobuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{uint64(s.count), uint64(s.time)})
+		out.message(2, sample.Bytes())
+	}
+	out.Write(body.Bytes())
+	for _, s := range table {
+		out.string(6, s)
+	}
+	out.uint(9, uint64(p.start.UnixNano()))
+	out.uint(10, uint64(now.Sub(p.start)))
+	valueType(11, "time", "nanoseconds")
+	out.uint(12, 1)
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	z := gzip.NewWriter(f)
+	if _, err := z.Write(out.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := z.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+func (b *protobuf) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protobuf) message(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.message(field, []byte(s))
+}
+
+func (b *protobuf) packed(field int, values []uint64) {
+	var v protobuf
+	for _, value := range values {
+		v.varint(value)
+	}
+	b.message(field, v.Bytes())
+}
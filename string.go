@@ -18,14 +18,34 @@ func (i *ifStatement) String() string {
 }
 
 func (w *whileStatement) String() string {
+	if w.label != "" {
+		return fmt.Sprintf("(while %s %s %s)", w.label, w.booleanExpression, w.block)
+	}
 	return fmt.Sprintf("(while %s %s)", w.booleanExpression, w.block)
 }
 
+func (f *forStatement) String() string {
+	var buf bytes.Buffer
+	if f.label != "" {
+		buf.WriteString(f.label + " ")
+	}
+	if f.key != "" {
+		buf.WriteString(f.key + " ")
+	}
+	return fmt.Sprintf("(for %s%s %s %s)", buf.String(), f.value, f.collection, f.block)
+}
+
 func (b *breakStatement) String() string {
+	if b.label != "" {
+		return fmt.Sprintf("(break %s)", b.label)
+	}
 	return fmt.Sprintf("(break)")
 }
 
 func (c *continueStatement) String() string {
+	if c.label != "" {
+		return fmt.Sprintf("(continue %s)", c.label)
+	}
 	return fmt.Sprintf("(continue)")
 }
 
@@ -48,6 +68,63 @@ func (r *returnStatement) String() string {
 	return fmt.Sprintf("(return %s)", r.expression)
 }
 
+func (s *structStatement) String() string {
+	var buf bytes.Buffer
+	for i, field := range s.fields {
+		if i != 0 {
+			buf.WriteRune(' ')
+		}
+		buf.WriteString(field)
+	}
+	return fmt.Sprintf("(struct %s %s)", s.name, buf.String())
+}
+
+func (c *classStatement) String() string {
+	var buf bytes.Buffer
+	for _, field := range c.fields {
+		buf.WriteString(" " + field.String())
+	}
+	for _, method := range c.methods {
+		buf.WriteString(" " + method.String())
+	}
+	if c.parent != "" {
+		return fmt.Sprintf("(class %s %s%s)", c.name, c.parent, buf.String())
+	}
+	return fmt.Sprintf("(class %s%s)", c.name, buf.String())
+}
+
+func (e *enumStatement) String() string {
+	var buf bytes.Buffer
+	for _, v := range e.variants {
+		if len(v.fields) == 0 {
+			buf.WriteString(" " + v.name)
+			continue
+		}
+		buf.WriteString(" (" + v.name)
+		for _, field := range v.fields {
+			buf.WriteString(" " + field)
+		}
+		buf.WriteRune(')')
+	}
+	return fmt.Sprintf("(enum %s%s)", e.name, buf.String())
+}
+
+func (i *importStatement) String() string {
+	return fmt.Sprintf("(import \"%s\")", i.path)
+}
+
+func (t *testStatement) String() string {
+	return fmt.Sprintf("(test \"%s\" %s)", t.name, t.block)
+}
+
+func (e *exportStatement) String() string {
+	return fmt.Sprintf("(export %s)", e.statement)
+}
+
+func (f *fieldAssignmentStatement) String() string {
+	return fmt.Sprintf("(fieldAssignment %s %s %s)", f.object, f.field, f.expression)
+}
+
 func (b *block) String() string {
 	var buf bytes.Buffer
 	if len(b.statements) > 0 {
@@ -100,6 +177,9 @@ func (c *callExpression) String() string {
 	} else {
 		buf.WriteString("nil")
 	}
+	if c.receiver != nil {
+		return fmt.Sprintf("(callExpression %s %s %s)", c.receiver, c.name, buf.String())
+	}
 	return fmt.Sprintf("(callExpression %s %s)", c.name, buf.String())
 }
 
@@ -115,6 +195,191 @@ func (s *stringLiteral) String() string {
 	return fmt.Sprintf("(stringLiteral \"%s\")", s.value)
 }
 
+func (i *interpolationExpression) String() string {
+	var buf bytes.Buffer
+	for j, e := range i.expressions {
+		buf.WriteString(fmt.Sprintf(" \"%s\" %s", i.segments[j], e))
+	}
+	return fmt.Sprintf("(interpolation%s \"%s\")", buf.String(), i.segments[len(i.segments)-1])
+}
+
 func (b *booleanLiteral) String() string {
 	return fmt.Sprintf("(booleanLiteral %t)", b.value)
 }
+
+func (l *listLiteral) String() string {
+	var buf bytes.Buffer
+	if len(l.elements) > 0 {
+		for i, e := range l.elements {
+			if i != 0 {
+				buf.WriteRune(' ')
+			}
+			buf.WriteString(e.String())
+		}
+	} else {
+		buf.WriteString("nil")
+	}
+	return fmt.Sprintf("(listLiteral %s)", buf.String())
+}
+
+func (m *mapLiteral) String() string {
+	var buf bytes.Buffer
+	if len(m.keys) > 0 {
+		for i, k := range m.keys {
+			if i != 0 {
+				buf.WriteRune(' ')
+			}
+			buf.WriteString(fmt.Sprintf("(%s %s)", k, m.values[i]))
+		}
+	} else {
+		buf.WriteString("nil")
+	}
+	return fmt.Sprintf("(mapLiteral %s)", buf.String())
+}
+
+func (s *structLiteral) String() string {
+	var buf bytes.Buffer
+	for i, field := range s.fields {
+		if i != 0 {
+			buf.WriteRune(' ')
+		}
+		buf.WriteString(fmt.Sprintf("(%s %s)", field, s.values[i]))
+	}
+	return fmt.Sprintf("(structLiteral %s %s)", s.name, buf.String())
+}
+
+func (f *fieldExpression) String() string {
+	return fmt.Sprintf("(fieldExpression %s %s)", f.object, f.field)
+}
+
+func (v *variantExpression) String() string {
+	var buf bytes.Buffer
+	for _, arg := range v.arguments {
+		buf.WriteString(" " + arg.String())
+	}
+	return fmt.Sprintf("(variantExpression %s.%s%s)", v.enum, v.variant, buf.String())
+}
+
+func (m *matchExpression) String() string {
+	var buf bytes.Buffer
+	for _, arm := range m.arms {
+		if arm.guard != nil {
+			buf.WriteString(fmt.Sprintf(" (arm %s (if %s) %s)", arm.pattern, arm.guard, arm.body))
+		} else {
+			buf.WriteString(fmt.Sprintf(" (arm %s %s)", arm.pattern, arm.body))
+		}
+	}
+	return fmt.Sprintf("(match %s%s)", m.subject, buf.String())
+}
+
+func (l *literalPattern) String() string {
+	return l.literal.String()
+}
+
+func (b *bindingPattern) String() string {
+	return fmt.Sprintf("(binding %s)", b.name)
+}
+
+func (w *wildcardPattern) String() string {
+	return "_"
+}
+
+func (v *variantPattern) String() string {
+	var buf bytes.Buffer
+	for _, pattern := range v.patterns {
+		buf.WriteString(" " + pattern.String())
+	}
+	return fmt.Sprintf("(%s.%s%s)", v.enum, v.variant, buf.String())
+}
+
+func (s *superExpression) String() string {
+	return "(super)"
+}
+
+func (e *expression) String() string {
+	return e.describe(map[interface{}]bool{})
+}
+
+// describe formats e, printing ... for the structs, objects and maps in
+// enclosing, which contain e.
+func (e *expression) describe(enclosing map[interface{}]bool) string {
+	if e == nil {
+		return "<nil>"
+	}
+	switch e.typeValue {
+	case stringType:
+		return fmt.Sprintf("\"%s\"", e.value.(string))
+	case listType:
+		var buf bytes.Buffer
+		buf.WriteRune('[')
+		for i, element := range e.value.([]*expression) {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(element.describe(enclosing))
+		}
+		buf.WriteRune(']')
+		return buf.String()
+	case mapType, objectType, structType:
+		if enclosing[e.value] {
+			return "..."
+		}
+		enclosing[e.value] = true
+		defer delete(enclosing, e.value)
+	}
+	switch e.typeValue {
+	case mapType:
+		var buf bytes.Buffer
+		m := e.value.(*mapValue)
+		buf.WriteRune('{')
+		for i, key := range m.keys {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprintf("%s: %s", key.describe(enclosing), m.get(key).describe(enclosing)))
+		}
+		buf.WriteRune('}')
+		return buf.String()
+	case rangeType:
+		r := e.value.(*rangeValue)
+		return fmt.Sprintf("range(%d, %d, %d)", r.start, r.end, r.step)
+	case objectType:
+		var buf bytes.Buffer
+		o := e.value.(*object)
+		buf.WriteString(o.class.name + "{")
+		for i, field := range classFields(o.class) {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprintf("%s: %s", field, o.fields[field].describe(enclosing)))
+		}
+		buf.WriteRune('}')
+		return buf.String()
+	case enumType:
+		v := e.value.(*variantValue)
+		if len(v.values) == 0 {
+			return fmt.Sprintf("%s.%s", v.enum.name, v.variant.name)
+		}
+		var buf bytes.Buffer
+		for i, value := range v.values {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(value.describe(enclosing))
+		}
+		return fmt.Sprintf("%s.%s(%s)", v.enum.name, v.variant.name, buf.String())
+	case structType:
+		var buf bytes.Buffer
+		v := e.value.(*structValue)
+		buf.WriteString(v.definition.name + "{")
+		for i, field := range v.definition.fields {
+			if i != 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprintf("%s: %s", field, v.fields[field].describe(enclosing)))
+		}
+		buf.WriteRune('}')
+		return buf.String()
+	}
+	return fmt.Sprint(e.value)
+}
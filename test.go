@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// testDeclaration matches a test at the top level of a file, which is how
+// the test command tells the files declaring tests from the others.
+var testDeclaration = regexp.MustCompile(`(?m)^test\s+"`)
+
+func testCommand(args []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	run := flags.String("run", "", "run only the tests whose names match the regular expression")
+	verbose := flags.Bool("v", false, "list each test as it runs, with its output")
+	flags.Parse(args)
+	pattern, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -run pattern: %s\n", err)
+		os.Exit(2)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no test files")
+		os.Exit(1)
+	}
+	failed := false
+	for _, file := range files {
+		if !testFile(file, pattern, *verbose) {
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
+
+// findTestFiles lists the files given and the files declaring tests in the
+// directories given.
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(file) != ".txt" {
+				return err
+			}
+			source, err := ioutil.ReadFile(file)
+			if err == nil && testDeclaration.Match(source) {
+				files = append(files, file)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testFile runs the tests of file whose names match pattern, reporting
+// whether they all passed.
+func testFile(file string, pattern *regexp.Regexp, verbose bool) bool {
+	start := time.Now()
+	names, err := testNames(file)
+	if err != nil {
+		fmt.Printf("FAIL\t%s\t%s\n", file, err)
+		return false
+	}
+	passed, ran := true, 0
+	for _, name := range names {
+		if !pattern.MatchString(name) {
+			continue
+		}
+		ran++
+		if verbose {
+			fmt.Printf("=== RUN   %s\n", name)
+		}
+		output, elapsed, err := runTest(file, name)
+		if err != nil {
+			passed = false
+			fmt.Printf("--- FAIL: %s (%.2fs)\n", name, elapsed.Seconds())
+		} else if verbose {
+			fmt.Printf("--- PASS: %s (%.2fs)\n", name, elapsed.Seconds())
+		}
+		if err != nil || verbose {
+			for _, line := range strings.SplitAfter(output, "\n") {
+				if line != "" {
+					fmt.Print("    " + line)
+				}
+			}
+			if !strings.HasSuffix(output, "\n") && output != "" {
+				fmt.Println()
+			}
+		}
+		if err != nil {
+			reportFailure(err)
+		}
+	}
+	switch {
+	case !passed:
+		fmt.Printf("FAIL\t%s\t%.3fs\n", file, time.Since(start).Seconds())
+	case ran == 0:
+		fmt.Printf("ok  \t%s\t%.3fs [no tests to run]\n", file, time.Since(start).Seconds())
+	default:
+		fmt.Printf("ok  \t%s\t%.3fs\n", file, time.Since(start).Seconds())
+	}
+	return passed
+}
+
+// testNames lists the tests declared in file, in order.
+func testNames(file string) (names []string, err error) {
+	reset()
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	for _, s := range load(file).block.statements {
+		if t, ok := s.(*testStatement); ok {
+			names = append(names, t.name)
+		}
+	}
+	return names, nil
+}
+
+// runTest runs the test name of file in a fresh interpreter, after the
+// statements at the top level of file, returning what it printed, how long
+// it took and the error that made it fail. A test calling exit fails, as
+// the process would otherwise end before the other tests run.
+func runTest(file string, name string) (output string, elapsed time.Duration, err error) {
+	reset()
+	var buffer bytes.Buffer
+	stdout = &buffer
+	start := time.Now()
+	defer func() {
+		stdout = os.Stdout
+		output, elapsed = buffer.String(), time.Since(start)
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case *runtimeError:
+				err = r
+			case *syntaxError:
+				err = r
+			case *exitError:
+				err = r
+			default:
+				panic(r)
+			}
+		}
+	}()
+	m := load(file)
+	m.evaluate()
+	for _, s := range m.block.statements {
+		if t, ok := s.(*testStatement); ok && t.name == name {
+			t.block.visitStatement(newScope(m.scope))
+		}
+	}
+	return
+}
+
+// reset forgets the modules loaded and the types declared, so that each
+// test runs as if it were the only one.
+func reset() {
+	modules = map[string]*module{}
+	declarationScopes = map[statementVisitor]*scope{}
+	calls = nil
+}
+
+// reportFailure describes err and, when the strings a failed assertion
+// compared span lines, how they differ.
+func reportFailure(err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+	r, ok := err.(*runtimeError)
+	if !ok || r.expected == nil || r.actual == nil || r.expected.typeValue != stringType || r.actual.typeValue != stringType {
+		return
+	}
+	expected, actual := display(r.expected), display(r.actual)
+	if !strings.Contains(expected, "\n") && !strings.Contains(actual, "\n") {
+		return
+	}
+	fmt.Println("    diff (- expected, + actual):")
+	for _, line := range diff(strings.Split(expected, "\n"), strings.Split(actual, "\n")) {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// diff lists the lines of a and b, marking those only in a with - and
+// those only in b with +, keeping the longest sequence common to both.
+func diff(a, b []string) []string {
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// tracer is an observer that logs each statement run, the value it
+// produced and each function entered and returned from to standard error,
+// indenting by the depth of calls.
+type tracer struct {
+	w       io.Writer
+	modules map[statementVisitor]*module
+	main    *module
+	depth   int
+	// lines holds the source lines of each module traced, split once.
+	lines map[*module][]string
+}
+
+func newTracer(m *module) *tracer {
+	t := &tracer{os.Stderr, map[statementVisitor]*module{}, m, 0, map[*module][]string{}}
+	for _, loaded := range modules {
+		collectStatements(loaded.block, loaded, t.modules)
+	}
+	return t
+}
+
+func (t *tracer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(t.w, "%s%s\n", strings.Repeat("  ", t.depth), fmt.Sprintf(format, args...))
+}
+
+// statement logs the position of s and the source line it starts on.
+func (t *tracer) statement(s statementVisitor, scope *scope) {
+	m, ok := t.modules[s]
+	if !ok {
+		m = t.main
+	}
+	at := s.position()
+	lines, ok := t.lines[m]
+	if !ok {
+		lines = strings.Split(string(m.source), "\n")
+		t.lines[m] = lines
+	}
+	text := ""
+	if at.line <= len(lines) {
+		text = lines[at.line-1]
+		if at.column-1 <= len(text) {
+			text = text[at.column-1:]
+		}
+	}
+	t.printf("%s:%d:%d: %s", m.path, at.line, at.column, strings.TrimSpace(text))
+}
+
+// result logs the value declared, assigned, returned or produced by a call
+// or match.
+func (t *tracer) result(s statementVisitor, value *expression) {
+	if e, ok := s.(*exportStatement); ok {
+		s = e.statement
+	}
+	switch s := s.(type) {
+	case *declarationStatement:
+		t.printf("%s = %s", s.id, describeSymbol(value))
+	case *assignmentStatement:
+		t.printf("%s = %s", s.id, describeSymbol(value))
+	case *fieldAssignmentStatement:
+		t.printf(".%s = %s", s.field, describeSymbol(value))
+	case *returnStatement:
+		if s.expression != nil {
+			t.printf("return %s", describeSymbol(value))
+		}
+	case *callExpression, *matchExpression:
+		if value != nil {
+			t.printf("=> %s", value)
+		}
+	}
+}
+
+// branch logs whether the block of an if or while statement runs.
+func (t *tracer) branch(s statementVisitor, taken bool) {
+	keyword := "if"
+	if _, ok := s.(*whileStatement); ok {
+		keyword = "while"
+	}
+	t.printf("%s %t", keyword, taken)
+}
+
+// enter logs the function called with its arguments.
+func (t *tracer) enter(f *functionStatement, scope *scope) {
+	t.depth++
+	var args []string
+	for _, p := range f.parameters {
+		args = append(args, fmt.Sprintf("%s = %s", p, describeSymbol(scope.symbols[p].value)))
+	}
+	t.printf("-> %s(%s)", f.name, strings.Join(args, ", "))
+}
+
+// exit logs the function returned from with the value it returned.
+func (t *tracer) exit(f *functionStatement, result *expression) {
+	if result != nil {
+		t.printf("<- %s = %s", f.name, result)
+	} else {
+		t.printf("<- %s", f.name)
+	}
+	t.depth--
+}
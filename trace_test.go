@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTrace checks that -trace logs each program of test/good/trace as its
+// .trace file records.
+func TestTrace(t *testing.T) {
+	files, err := filepath.Glob("test/good/trace/*.trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no .trace files")
+	}
+	defer func() { observers, stdout = nil, os.Stdout }()
+	stdout = ioutil.Discard
+	for _, file := range files {
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reset()
+		m := load(strings.TrimSuffix(file, ".trace") + ".txt")
+		optimizeModules()
+		var buffer bytes.Buffer
+		tracer := newTracer(m)
+		tracer.w = &buffer
+		observers = []observer{tracer}
+		m.evaluate()
+		if got := buffer.String(); got != string(want) {
+			t.Errorf("%s:\ngot  %s\nwant %s", file, got, want)
+		}
+	}
+}